@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	fp "path/filepath"
+
+	rp "github.com/pragmatically-dev/PoC-drawj2d-port-go/remarkablepage"
+	"gopkg.in/yaml.v3"
+)
+
+// ERROR_HISTORY is the number of errors the API reports
+const ERROR_HISTORY = 50
+
+// MAX_CONVERT_SIZE bounds the images accepted by POST /convert
+const MAX_CONVERT_SIZE = 32 << 20
+
+// currentConfig is the config in use, kept up to date by applyConfig
+var currentConfig atomic.Pointer[Config]
+
+// watching pauses and resumes the watcher
+var watching = &watchControl{resume: make(chan struct{}, 1)}
+
+// watchControl lets the API pause the watcher. Screenshots taken while paused
+// stay in place and are picked up on resume.
+type watchControl struct {
+	paused       atomic.Bool
+	resume       chan struct{}
+	sessionPages atomic.Int64 // Screenshots in the open multiple mode session
+}
+
+func (control *watchControl) pause() {
+	control.paused.Store(true)
+}
+
+func (control *watchControl) unpause() {
+	if control.paused.Swap(false) {
+		select {
+		case control.resume <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// recentErrors keeps the last conversion and delivery errors for the API
+var recentErrors = &errorLog{}
+
+type errorEntry struct {
+	Time    time.Time `json:"time"`
+	Source  string    `json:"source"` // Screenshot or document the error is about
+	Message string    `json:"message"`
+}
+
+type errorLog struct {
+	mu      sync.Mutex
+	entries []errorEntry
+}
+
+func (history *errorLog) add(source string, err error) {
+	history.mu.Lock()
+	defer history.mu.Unlock()
+
+	history.entries = append(history.entries, errorEntry{Time: time.Now(), Source: source, Message: err.Error()})
+	if len(history.entries) > ERROR_HISTORY {
+		history.entries = history.entries[len(history.entries)-ERROR_HISTORY:]
+	}
+}
+
+func (history *errorLog) list() []errorEntry {
+	history.mu.Lock()
+	defer history.mu.Unlock()
+	return append([]errorEntry{}, history.entries...)
+}
+
+// apiHandler serves the control API:
+//
+//	GET  /status         paused, pending jobs and uploads
+//	GET  /jobs           conversion jobs, GET /jobs/{id} for one
+//	GET  /uploads        documents waiting in the upload queue
+//	GET  /errors         last conversion and delivery errors
+//	GET  /config         config in use
+//	GET  /metrics        stage timings and counters in the Prometheus text format, /metrics.json as JSON
+//	POST /convert        image in the body or a "file" form field, returns the .rmdoc
+//	POST /pause          stop converting new screenshots
+//	POST /resume         convert them again, including the ones taken while paused
+//	POST /log_level      set the log level to the level query parameter until the next reload
+func apiHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		active := 0
+		for _, job := range processing.Jobs() {
+			if job.State != JOB_DONE && job.State != JOB_FAILED {
+				active++
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"paused":          watching.paused.Load(),
+			"session_pages":   watching.sessionPages.Load(),
+			"log_level":       logLevel.Level().String(),
+			"active_jobs":     active,
+			"pending_uploads": uploads.Pending(),
+		})
+	})
+
+	mux.HandleFunc("GET /jobs", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, processing.Jobs())
+	})
+
+	mux.HandleFunc("GET /jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		for _, job := range processing.Jobs() {
+			if job.ID == r.PathValue("id") {
+				writeJSON(w, http.StatusOK, job)
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, fmt.Errorf("no job %s", r.PathValue("id")))
+	})
+
+	mux.HandleFunc("GET /uploads", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, uploads.Jobs())
+	})
+
+	mux.HandleFunc("GET /errors", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, recentErrors.list())
+	})
+
+	mux.HandleFunc("GET /config", func(w http.ResponseWriter, r *http.Request) {
+		// Through YAML so the keys are the ones of the config file
+		data, err := yaml.Marshal(currentConfig.Load())
+		var settings map[string]any
+		if err == nil {
+			err = yaml.Unmarshal(data, &settings)
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, settings)
+	})
+
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		metrics.WritePrometheus(w)
+	})
+
+	mux.HandleFunc("GET /metrics.json", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, metrics.Snapshot())
+	})
+
+	mux.HandleFunc("POST /convert", handleConvert)
+
+	mux.HandleFunc("POST /pause", func(w http.ResponseWriter, r *http.Request) {
+		watching.pause()
+		writeJSON(w, http.StatusOK, map[string]bool{"paused": true})
+	})
+
+	mux.HandleFunc("POST /resume", func(w http.ResponseWriter, r *http.Request) {
+		watching.unpause()
+		writeJSON(w, http.StatusOK, map[string]bool{"paused": false})
+	})
+
+	mux.HandleFunc("POST /log_level", func(w http.ResponseWriter, r *http.Request) {
+		level, err := parseLogLevel(r.URL.Query().Get("level"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		logLevel.Set(level)
+		slog.Info("log level changed", "level", level)
+		writeJSON(w, http.StatusOK, map[string]string{"log_level": level.String()})
+	})
+
+	return mux
+}
+
+// handleConvert converts the uploaded image with the mode and name query
+// parameters and answers with the .rmdoc, without delivering it
+func handleConvert(w http.ResponseWriter, r *http.Request) {
+	config := currentConfig.Load()
+
+	mode := rp.MODE_EDGES
+	if r.URL.Query().Has("mode") {
+		var err error
+		if mode, err = rp.ParseMode(r.URL.Query().Get("mode")); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, MAX_CONVERT_SIZE)
+	image, name := io.Reader(r.Body), "Image"
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("reading the file field: %w", err))
+			return
+		}
+		defer file.Close()
+		image, name = file, header.Filename
+	}
+	name = orDefault(r.URL.Query().Get("name"), name)
+
+	// The pipelines read the image from a file
+	tmp, err := os.CreateTemp("", "drawj2d-*"+fp.Ext(name))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, image)
+	tmp.Close()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("reading the image: %w", err))
+		return
+	}
+
+	landscape := config.landscape(tmp.Name())
+	pages, err := processing.ConvertNow(config, tmp.Name(), mode, landscape)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	opts := config.docOpts
+	opts.Landscape = landscape
+	rmdoc, rmDocPath, err := rp.CreateRmDocWithOptions(trimExt(fp.Base(name)), pages, opts)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fp.Base(rmDocPath)))
+	w.Write(rmdoc.Bytes())
+}
+
+// trimExt removes the extension of a file name
+func trimExt(name string) string {
+	return name[:len(name)-len(fp.Ext(name))]
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// serveAPI runs the control API on config.APIListen until ctx is done
+func serveAPI(ctx context.Context, config *Config) error {
+	listener, err := net.Listen("tcp", config.APIListen)
+	if err != nil {
+		return fmt.Errorf("control API: %w", err)
+	}
+	server := &http.Server{Handler: apiHandler(), ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdown)
+	}()
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("control API stopped", "err", err)
+		}
+	}()
+
+	slog.Info("control API listening", "url", "http://"+listener.Addr().String())
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	rp "github.com/pragmatically-dev/PoC-drawj2d-port-go/remarkablepage"
+)
+
+// testAPI serves the control API over a processor converting with the real pipelines
+func testAPI(t *testing.T) (*httptest.Server, *Config) {
+	config, _ := startProcessing(t, testQueueConfig(), rp.ConvertImageLayout, func(string, []byte, string) error { return nil })
+	currentConfig.Store(config)
+	server := httptest.NewServer(apiHandler())
+	t.Cleanup(server.Close)
+	return server, config
+}
+
+func pngImage(t *testing.T) []byte {
+	img := image.NewGray(image.Rect(0, 0, 20, 20))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	for x := 2; x < 18; x++ {
+		img.Pix[10*img.Stride+x] = 0
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestAPIConvert(t *testing.T) {
+	server, config := testAPI(t)
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("file", "Sketch.png")
+	part.Write(pngImage(t))
+	form.Close()
+
+	resp, err := http.Post(server.URL+"/convert?mode=fill", form.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %s: %s", resp.Status, data)
+	}
+	if disposition := resp.Header.Get("Content-Disposition"); disposition != `attachment; filename="Sketch.rmdoc"` {
+		t.Errorf("Content-Disposition = %s", disposition)
+	}
+	info, err := rp.ReadRmDoc(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Pages) != 1 {
+		t.Errorf("%d pages, want 1", len(info.Pages))
+	}
+
+	// Tiled like the watched screenshots
+	config.Tile, config.TileScale = true, 200
+	resp, err = http.Post(server.URL+"/convert", "image/png", bytes.NewReader(pngImage(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if info, err = rp.ReadRmDoc(data); err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Pages) < 2 {
+		t.Errorf("tiled conversion made %d pages, want several", len(info.Pages))
+	}
+	config.Tile = false
+
+	resp, err = http.Post(server.URL+"/convert?mode=sketch", "image/png", bytes.NewReader(pngImage(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown mode answered %s", resp.Status)
+	}
+}
+
+func TestAPIPauseAndStatus(t *testing.T) {
+	server, config := testAPI(t)
+	t.Cleanup(func() { watching.paused.Store(false) })
+
+	status := func() map[string]any {
+		resp, err := http.Get(server.URL + "/status")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var status map[string]any
+		json.NewDecoder(resp.Body).Decode(&status)
+		return status
+	}
+
+	http.Post(server.URL+"/pause", "", nil)
+	if status()["paused"] != true {
+		t.Error("not paused after POST /pause")
+	}
+	http.Post(server.URL+"/resume", "", nil)
+	if status()["paused"] != false {
+		t.Error("still paused after POST /resume")
+	}
+	select {
+	case <-watching.resume:
+	default:
+		t.Error("the watcher was not told to pick up the screenshots taken while paused")
+	}
+
+	resp, err := http.Get(server.URL + "/config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var settings map[string]any
+	json.NewDecoder(resp.Body).Decode(&settings)
+	if settings["dir_to_search"] != config.DirToSearch {
+		t.Errorf("config dir_to_search = %v", settings["dir_to_search"])
+	}
+}
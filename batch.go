@@ -0,0 +1,200 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	fp "path/filepath"
+
+	rp "github.com/pragmatically-dev/PoC-drawj2d-port-go/remarkablepage"
+)
+
+// Page orders of the batch command
+const (
+	SORT_NAME  = "name"  // File name, numbers compared by value: Slide2 before Slide10
+	SORT_MTIME = "mtime" // Modification time
+	SORT_EXIF  = "exif"  // Date the picture was taken, the modification time without one
+)
+
+// IMAGE_EXTENSIONS are the files the batch command converts
+var IMAGE_EXTENSIONS = []string{".png", ".jpg", ".jpeg"}
+
+func batchCommand(args []string) error {
+	fs := flag.NewFlagSet("batch", flag.ExitOnError)
+	output := fs.String("o", "", "output path, named after the directory by default")
+	mode := fs.String("mode", string(rp.MODE_EDGES), fmt.Sprintf("pipeline mode, one of %v", rp.Modes))
+	format := fs.String("format", FORMAT_RMDOC, "output format: rmdoc, xochitl or rm")
+	dest := fs.String("dest", DEST_FILE, "destination: file, web or sink")
+	order := fs.String("sort", SORT_NAME, "page order: name, mtime or exif")
+	jobs := fs.Int("jobs", 0, "images converted at once, the workers setting when 0")
+	configPath, overrides := RegisterConfigFlags(fs)
+
+	dirs, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(dirs) != 1 {
+		return fmt.Errorf("batch: expected one input directory")
+	}
+	pipelineMode, err := rp.ParseMode(*mode)
+	if err != nil {
+		return err
+	}
+	if *dest != DEST_FILE && *format != FORMAT_RMDOC {
+		return fmt.Errorf("batch: only the rmdoc format can be delivered")
+	}
+
+	config, err := loadCommandConfig(configPath, overrides)
+	if err != nil {
+		return err
+	}
+
+	images, err := listImages(dirs[0])
+	if err != nil {
+		return err
+	}
+	if len(images) == 0 {
+		return fmt.Errorf("batch: no image in %s", dirs[0])
+	}
+	if err := sortImages(images, *order); err != nil {
+		return err
+	}
+
+	workers := *jobs
+	if workers <= 0 {
+		workers = config.Workers
+	}
+	landscape := config.landscape(images[0])
+	pages, err := convertPages(config, images, pipelineMode, landscape, workers)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%d pages converted from %s\n", len(pages), dirs[0])
+
+	opts, err := config.documentOptions(images[0])
+	if err != nil {
+		return err
+	}
+	opts.Landscape = landscape
+	name := fp.Base(fp.Clean(dirs[0]))
+	return writeDocument(config, name, pages, opts, *output, *format, *dest)
+}
+
+// listImages returns the images directly in dir
+func listImages(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var images []string
+	for _, entry := range entries {
+		ext := strings.ToLower(fp.Ext(entry.Name()))
+		if entry.Type().IsRegular() && slices.Contains(IMAGE_EXTENSIONS, ext) {
+			images = append(images, fp.Join(dir, entry.Name()))
+		}
+	}
+	return images, nil
+}
+
+// sortImages puts the images in page order, by name when their dates are equal
+func sortImages(images []string, order string) error {
+	var dates map[string]time.Time
+	switch order {
+	case SORT_NAME:
+	case SORT_MTIME, SORT_EXIF:
+		dates = make(map[string]time.Time, len(images))
+		for _, image := range images {
+			info, err := os.Stat(image)
+			if err != nil {
+				return err
+			}
+			dates[image] = info.ModTime()
+			if order == SORT_EXIF {
+				if taken, ok := exifDate(image); ok {
+					dates[image] = taken
+				}
+			}
+		}
+	default:
+		return fmt.Errorf("%q is not one of name, mtime, exif", order)
+	}
+
+	sort.SliceStable(images, func(i, j int) bool {
+		if di, dj := dates[images[i]], dates[images[j]]; !di.Equal(dj) {
+			return di.Before(dj)
+		}
+		return naturalLess(fp.Base(images[i]), fp.Base(images[j]))
+	})
+	return nil
+}
+
+// naturalLess compares names with their runs of digits compared by value
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		da, db := digitPrefix(a), digitPrefix(b)
+		if da != "" && db != "" {
+			na, nb := strings.TrimLeft(da, "0"), strings.TrimLeft(db, "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			a, b = a[len(da):], b[len(db):]
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func digitPrefix(s string) string {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i]
+}
+
+// convertPages converts the images on up to jobs goroutines and returns the
+// pages in the order of the images, the tiles of an image in reading order
+func convertPages(config *Config, images []string, mode rp.Mode, landscape bool, jobs int) ([][]byte, error) {
+	converted := make([][][]byte, len(images))
+	errs := make([]error, len(images))
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < max(1, min(jobs, len(images))); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				converted[i], _, errs[i] = config.convertImage(images[i], mode, landscape)
+			}
+		}()
+	}
+	for i := range images {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	var pages [][]byte
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", images[i], err)
+		}
+		pages = append(pages, converted[i]...)
+	}
+	return pages, nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	fp "path/filepath"
+
+	rp "github.com/pragmatically-dev/PoC-drawj2d-port-go/remarkablepage"
+)
+
+// jpegWithExif returns the start of a JPEG whose EXIF says it was taken at date
+func jpegWithExif(date string) []byte {
+	// Little endian TIFF: IFD0 with the EXIF IFD pointer, then the EXIF IFD
+	// with DateTimeOriginal, then the date
+	tiff := []byte("II*\x00")
+	tiff = binary.LittleEndian.AppendUint32(tiff, 8)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 1)
+	tiff = binary.LittleEndian.AppendUint16(tiff, EXIF_IFD_POINTER)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 4)
+	tiff = binary.LittleEndian.AppendUint32(tiff, 1)
+	tiff = binary.LittleEndian.AppendUint32(tiff, 26)
+	tiff = binary.LittleEndian.AppendUint32(tiff, 0)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 1)
+	tiff = binary.LittleEndian.AppendUint16(tiff, EXIF_DATE_TIME_ORIGINAL)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 2)
+	tiff = binary.LittleEndian.AppendUint32(tiff, uint32(len(date)+1))
+	tiff = binary.LittleEndian.AppendUint32(tiff, 44)
+	tiff = binary.LittleEndian.AppendUint32(tiff, 0)
+	tiff = append(tiff, date+"\x00"...)
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	jpeg := []byte{0xff, 0xd8, 0xff, 0xe1}
+	jpeg = binary.BigEndian.AppendUint16(jpeg, uint16(len(segment)+2))
+	jpeg = append(jpeg, segment...)
+	return append(jpeg, 0xff, 0xda)
+}
+
+func TestExifDate(t *testing.T) {
+	path := fp.Join(t.TempDir(), "IMG_0001.jpg")
+	os.WriteFile(path, jpegWithExif("2023:07:14 09:30:00"), 0644)
+
+	taken, ok := exifDate(path)
+	if want := time.Date(2023, 7, 14, 9, 30, 0, 0, time.Local); !ok || !taken.Equal(want) {
+		t.Errorf("exifDate = %v, %v, want %v", taken, ok, want)
+	}
+
+	os.WriteFile(path, pngImage(t), 0644)
+	if _, ok := exifDate(path); ok {
+		t.Error("date found in a PNG without EXIF")
+	}
+}
+
+func TestExifBlockRejectsCorruptSegments(t *testing.T) {
+	for _, size := range []byte{0, 1} {
+		jpeg := []byte{0xff, 0xd8, 0xff, 0xe1, 0x00, size, 'E', 'x', 'i', 'f'}
+		if block := exifBlock(jpeg); block != nil {
+			t.Errorf("segment of length %d: %q", size, block)
+		}
+	}
+}
+
+func TestSortImages(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data []byte, modTime time.Time) string {
+		path := fp.Join(dir, name)
+		os.WriteFile(path, data, 0644)
+		os.Chtimes(path, modTime, modTime)
+		return path
+	}
+	now := time.Now()
+	slide10 := write("Slide10.png", pngImage(t), now.Add(-3*time.Hour))
+	slide2 := write("Slide2.png", pngImage(t), now.Add(-1*time.Hour))
+	photo := write("photo.jpg", jpegWithExif("2001:01:01 00:00:00"), now)
+
+	for order, want := range map[string][]string{
+		SORT_NAME:  {slide2, slide10, photo},
+		SORT_MTIME: {slide10, slide2, photo},
+		SORT_EXIF:  {photo, slide10, slide2},
+	} {
+		images := []string{slide10, photo, slide2}
+		if err := sortImages(images, order); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(images, want) {
+			t.Errorf("sorted by %s: %v, want %v", order, images, want)
+		}
+	}
+}
+
+func TestConvertPagesKeepsOrder(t *testing.T) {
+	dir := t.TempDir()
+	var images []string
+	for i := 0; i < 4; i++ {
+		img := image.NewGray(image.Rect(0, 0, 20, 20))
+		for x := 0; x < 4*(i+1); x++ {
+			img.Pix[10*img.Stride+x] = 255
+		}
+		var buf bytes.Buffer
+		png.Encode(&buf, img)
+		path := fp.Join(dir, fmt.Sprintf("page%d.png", i))
+		os.WriteFile(path, buf.Bytes(), 0644)
+		images = append(images, path)
+	}
+
+	pages, err := convertPages(defaultConfig(), images, rp.MODE_FILL, false, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, path := range images {
+		want, _ := rp.ConvertImage(path, rp.MODE_FILL)
+		if !bytes.Equal(pages[i], want) {
+			t.Errorf("page %d is not %s", i+1, fp.Base(path))
+		}
+	}
+
+	if _, err := convertPages(defaultConfig(), []string{images[0], fp.Join(dir, "missing.png")}, rp.MODE_FILL, false, 2); err == nil {
+		t.Error("missing image converted")
+	}
+}
+
+func TestConvertPagesTilesLargeImages(t *testing.T) {
+	dir := t.TempDir()
+	tall := fp.Join(dir, "tall.png")
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 100, 400)))
+	os.WriteFile(tall, buf.Bytes(), 0644)
+
+	config := defaultConfig()
+	config.Tile, config.TileOverlap = true, 0
+	pages, err := convertPages(config, []string{tall, tall}, rp.MODE_FILL, false, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 6 {
+		t.Errorf("%d pages, want 3 tiles per image", len(pages))
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image/png"
+	"log/slog"
+	"os"
+	"strings"
+
+	fp "path/filepath"
+
+	rp "github.com/pragmatically-dev/PoC-drawj2d-port-go/remarkablepage"
+)
+
+// Output formats of the convert command
+const (
+	FORMAT_RMDOC   = "rmdoc"   // Zip archive accepted by the web interface
+	FORMAT_XOCHITL = "xochitl" // Loose files in the tablet storage layout
+	FORMAT_RM      = "rm"      // Bare .rm lines files, one per page
+)
+
+// Destinations of the convert command
+const (
+	DEST_FILE = "file" // Write to the -o path
+	DEST_WEB  = "web"  // Upload to the USB web interface
+	DEST_SINK = "sink" // Deliver through the sink of the config
+)
+
+type command struct {
+	run   func(args []string) error
+	usage string
+}
+
+var commands = map[string]command{
+	"watch":   {AppStart, "watch [flags]                      convert new screenshots as they appear (default)"},
+	"convert": {convertCommand, "convert [flags] <image>... -o <out> convert images into one document"},
+	"batch":   {batchCommand, "batch [flags] <dir> -o <out>       convert every image of a directory into one notebook"},
+	"render":  {renderCommand, "render <in.rm|in.rmdoc> -o <out.png> draw the strokes of a document"},
+	"inspect": {inspectCommand, "inspect <in.rm|in.rmdoc>...         print document metadata and stroke counts"},
+	"upload":  {uploadCommand, "upload [flags] <in.rmdoc>...        deliver documents to the library"},
+}
+
+// runCommand dispatches to a subcommand. Without one, or when the first
+// argument is a flag, it runs the watcher so the service unit keeps working.
+func runCommand(args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return AppStart(args)
+	}
+
+	name := args[0]
+	if name == "help" {
+		printUsage()
+		return nil
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		printUsage()
+		return fmt.Errorf("unknown command %q", name)
+	}
+	return cmd.run(args[1:])
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "usage: drawj2d-go <command> [flags] [args]")
+	for _, name := range []string{"watch", "convert", "batch", "render", "inspect", "upload"} {
+		fmt.Fprintln(os.Stderr, "  "+commands[name].usage)
+	}
+	fmt.Fprintln(os.Stderr, "run drawj2d-go <command> -h for the flags of a command")
+}
+
+// parseInterspersed parses flags placed anywhere among the positional
+// arguments, e.g. "convert in.png -o out.rmdoc". Everything after "--" is
+// positional, so that file names may start with a dash.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if parsed := len(args) - len(rest); parsed > 0 && args[parsed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// loadCommandConfig loads the config for a one-shot command
+func loadCommandConfig(configPath *string, overrides ConfigOverrides) (*Config, error) {
+	config, err := loadAndPrepareConfig(*configPath, overrides)
+	if err != nil {
+		return nil, err
+	}
+	if config.missingFolders {
+		slog.Warn("destination folders are created by the watcher, documents go to the library root until then")
+	}
+	applyConfig(config)
+	return config, nil
+}
+
+func convertCommand(args []string) error {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	output := fs.String("o", "", "output path, named after the first image by default")
+	mode := fs.String("mode", string(rp.MODE_EDGES), fmt.Sprintf("pipeline mode, one of %v", rp.Modes))
+	format := fs.String("format", FORMAT_RMDOC, "output format: rmdoc, xochitl or rm")
+	dest := fs.String("dest", DEST_FILE, "destination: file, web or sink")
+	configPath, overrides := RegisterConfigFlags(fs)
+
+	images, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(images) == 0 {
+		return fmt.Errorf("convert: no input image")
+	}
+	pipelineMode, err := rp.ParseMode(*mode)
+	if err != nil {
+		return err
+	}
+	if *dest != DEST_FILE && *format != FORMAT_RMDOC {
+		return fmt.Errorf("convert: only the rmdoc format can be delivered")
+	}
+
+	config, err := loadCommandConfig(configPath, overrides)
+	if err != nil {
+		return err
+	}
+
+	landscape := config.landscape(images[0])
+	pages, err := convertPages(config, images, pipelineMode, landscape, config.Workers)
+	if err != nil {
+		return err
+	}
+
+	opts, err := config.documentOptions(images[0])
+	if err != nil {
+		return err
+	}
+	opts.Landscape = landscape
+	return writeDocument(config, config.namer.Name(images[0]), pages, opts, *output, *format, *dest)
+}
+
+// writeDocument writes the pages as one document in format to output, or
+// delivers it to dest
+func writeDocument(config *Config, name string, pages [][]byte, opts rp.DocOptions, output, format, dest string) error {
+	switch {
+	case dest == DEST_WEB || dest == DEST_SINK:
+		sinkConfig := config.Sink
+		if dest == DEST_WEB {
+			sinkConfig = SinkConfig{Type: SINK_WEB}
+		}
+		sink, err := newSink(sinkConfig, config)
+		if err != nil {
+			return err
+		}
+		rmdoc, rmDocPath, err := rp.CreateRmDocWithOptions(name, pages, opts)
+		if err != nil {
+			return err
+		}
+		return sink.Deliver(fp.Base(rmDocPath), rmdoc.Bytes(), opts.Parent)
+
+	case dest != DEST_FILE:
+		return fmt.Errorf("unknown destination %q", dest)
+
+	case format == FORMAT_RMDOC:
+		rmdoc, rmDocPath, err := rp.CreateRmDocWithOptions(name, pages, opts)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(orDefault(output, rmDocPath), rmdoc.Bytes(), 0644)
+
+	case format == FORMAT_XOCHITL:
+		id, err := rp.ExportXochitlDir(orDefault(output, "."), name, pages, opts)
+		if err == nil {
+			fmt.Println(id)
+		}
+		return err
+
+	case format == FORMAT_RM:
+		for i, page := range pages {
+			if err := os.WriteFile(numberedPath(orDefault(output, name+".rm"), i), page, 0644); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown format %q", format)
+}
+
+// numberedPath returns path for the first page and path-N for page N after it
+func numberedPath(path string, i int) string {
+	if i == 0 {
+		return path
+	}
+	ext := fp.Ext(path)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(path, ext), i+1, ext)
+}
+
+// readPages returns the pages of an .rm or .rmdoc file in document order,
+// nil standing for a page without strokes
+func readPages(path string) ([][]byte, *rp.RmDocInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if bytes.HasPrefix(data, []byte(rp.HEADER_V5)) {
+		return [][]byte{data}, nil, nil
+	}
+
+	info, err := rp.ReadRmDoc(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	pages := make([][]byte, len(info.PageIDs))
+	for i, id := range info.PageIDs {
+		pages[i] = info.Pages[id]
+	}
+	return pages, info, nil
+}
+
+func renderCommand(args []string) error {
+	fs := flag.NewFlagSet("render", flag.ExitOnError)
+	output := fs.String("o", "", "output PNG, numbered per page, named after the input by default")
+
+	inputs, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(inputs) != 1 {
+		return fmt.Errorf("render: expected one input document")
+	}
+
+	pages, info, err := readPages(inputs[0])
+	if err != nil {
+		return err
+	}
+	landscape := info != nil && info.Orientation == ORIENTATION_LANDSCAPE
+	width, height := float32(rp.X_MAX), float32(rp.Y_MAX)
+	if info != nil && info.PageWidth > 0 && info.PageHeight > 0 {
+		width, height = float32(info.PageWidth), float32(info.PageHeight)
+	}
+
+	out := orDefault(*output, rp.GetFileNameWithoutExtension(inputs[0])+".png")
+	for i, data := range pages {
+		page := rp.NewReMarkablePage()
+		if data != nil {
+			if page, err = rp.ParsePage(data); err != nil {
+				return fmt.Errorf("page %d: %w", i+1, err)
+			}
+		}
+
+		file, err := os.Create(numberedPath(out, i))
+		if err != nil {
+			return err
+		}
+		img := page.RenderSize(width, height)
+		if landscape {
+			img = rp.RotateLandscape(img)
+		}
+		err = png.Encode(file, img)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func inspectCommand(args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+
+	docs, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(docs) == 0 {
+		return fmt.Errorf("inspect: no input document")
+	}
+
+	for _, path := range docs {
+		pages, info, err := readPages(path)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(path + ":")
+		if info != nil {
+			fmt.Printf("  id:        %s\n", info.ID)
+			fmt.Printf("  name:      %s\n", info.VisibleName)
+			fmt.Printf("  type:      %s\n", info.FileType)
+			fmt.Printf("  parent:    %s\n", orDefault(info.Parent, "(root)"))
+			fmt.Printf("  pinned:    %t\n", info.Pinned)
+			fmt.Printf("  landscape: %t\n", info.Orientation == ORIENTATION_LANDSCAPE)
+			if info.PageWidth > 0 {
+				fmt.Printf("  page size: %dx%d\n", info.PageWidth, info.PageHeight)
+			}
+			fmt.Printf("  tags:      %s\n", strings.Join(info.Tags, ", "))
+			if info.PDFSize > 0 {
+				fmt.Printf("  pdf:       %d bytes\n", info.PDFSize)
+			}
+		}
+		fmt.Printf("  pages:     %d\n", len(pages))
+
+		for i, data := range pages {
+			if data == nil {
+				fmt.Printf("  page %d:    no strokes\n", i+1)
+				continue
+			}
+			page, err := rp.ParsePage(data)
+			if err != nil {
+				return fmt.Errorf("page %d: %w", i+1, err)
+			}
+			lines, points := page.Stats()
+			fmt.Printf("  page %d:    %d lines, %d points, %d bytes\n", i+1, lines, points, len(data))
+		}
+	}
+	return nil
+}
+
+func uploadCommand(args []string) error {
+	fs := flag.NewFlagSet("upload", flag.ExitOnError)
+	configPath, overrides := RegisterConfigFlags(fs)
+
+	docs, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(docs) == 0 {
+		return fmt.Errorf("upload: no input document")
+	}
+
+	config, err := loadCommandConfig(configPath, overrides)
+	if err != nil {
+		return err
+	}
+
+	sink, err := newSink(config.Sink, config)
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		data, err := os.ReadFile(doc)
+		if err != nil {
+			return err
+		}
+		if err := sink.Deliver(fp.Base(doc), data, config.docOpts.Parent); err != nil {
+			return fmt.Errorf("delivering %s: %w", doc, err)
+		}
+		fmt.Println("Delivered", doc, "to", sink)
+	}
+	return nil
+}
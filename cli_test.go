@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"slices"
+	"testing"
+)
+
+func TestParseInterspersed(t *testing.T) {
+	for _, test := range []struct {
+		args       []string
+		positional []string
+		output     string
+	}{
+		{[]string{"in.png", "-o", "out.rmdoc", "other.png"}, []string{"in.png", "other.png"}, "out.rmdoc"},
+		{[]string{"-o", "out.rmdoc", "--", "-dash.png", "-o"}, []string{"-dash.png", "-o"}, "out.rmdoc"},
+		{[]string{"in.png", "--", "--"}, []string{"in.png", "--"}, ""},
+	} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		output := fs.String("o", "", "")
+		positional, err := parseInterspersed(fs, test.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(positional, test.positional) || *output != test.output {
+			t.Errorf("%q: positional %q, -o %q", test.args, positional, *output)
+		}
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	if _, err := parseInterspersed(fs, []string{"doc.rmdoc", "-h"}); err != flag.ErrHelp {
+		t.Errorf("-h among the files: %v", err)
+	}
+}
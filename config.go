@@ -0,0 +1,640 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	fp "path/filepath"
+
+	rp "github.com/pragmatically-dev/PoC-drawj2d-port-go/remarkablepage"
+	"gopkg.in/yaml.v3"
+)
+
+// USB_ADDRESS is the address of the tablet on its USB network
+const USB_ADDRESS = "10.11.99.1"
+
+// ENV_PREFIX prefixes the environment variables overriding config keys,
+// e.g. DRAWJ2D_DIR_TO_SEARCH overrides dir_to_search
+const ENV_PREFIX = "DRAWJ2D_"
+
+// Config structure to hold YAML configuration
+type Config struct {
+	DirToSearch string   `yaml:"dir_to_search"`
+	FilePrefix  string   `yaml:"file_prefix"`
+	Folder      string   `yaml:"folder"` // Destination folder, by ID or by path of names
+	Pinned      bool     `yaml:"pinned"`
+	Tags        []string `yaml:"tags"`
+	PageTags    []string `yaml:"page_tags"` // Tags applied to every converted page
+
+	// Deterministic derives IDs from the screenshot contents and stamps the
+	// documents with DeterministicTime, an RFC 3339 time, so the same contents
+	// always give the same .rmdoc
+	Deterministic     bool   `yaml:"deterministic"`
+	DeterministicTime string `yaml:"deterministic_time"`
+
+	// PDF is the path of a reference PDF the converted pages are overlaid on,
+	// producing a PDF document instead of a notebook
+	PDF string `yaml:"pdf"`
+
+	// ExportDir additionally writes every conversion in the xochitl storage
+	// layout to this directory, ready to be copied or rsynced to a tablet
+	ExportDir string `yaml:"export_dir"`
+
+	// Device is the tablet model the documents are made for, see rp.Devices,
+	// setting the page size images are fitted to and the inks Color may name
+	Device string `yaml:"device"`
+	Color  string `yaml:"color"`
+
+	// Orientation of the pages: portrait, landscape, or auto for landscape
+	// when the first image of a document is wider than high
+	Orientation string `yaml:"orientation"`
+
+	// Tile cuts images larger than a page into page sized tiles, scaled by
+	// TileScale page pixels per image pixel, 0 fitting the image to the width
+	// of the page, neighbouring tiles sharing TileOverlap page pixels.
+	// Without it every image is scaled to fit one page.
+	Tile        bool    `yaml:"tile"`
+	TileScale   float64 `yaml:"tile_scale"`
+	TileOverlap float64 `yaml:"tile_overlap"`
+
+	// NameTemplate builds the document names, see Namer for the placeholders
+	NameTemplate string `yaml:"name_template"`
+
+	UploadURL    string        `yaml:"upload_url"`    // Base URL of the USB web interface
+	HTTPTimeout  time.Duration `yaml:"http_timeout"`  // Timeout of every request to the web interface
+	XochitlDir   string        `yaml:"xochitl_dir"`   // Library storage, where folders are looked up
+	WriteTimeout time.Duration `yaml:"write_timeout"` // Longest wait for a new screenshot to be fully written
+
+	// MultipleMode batches screenshots into one notebook: on, off, or auto to
+	// follow MultipleModeKey ("Section/Key" or a bare key) in XochitlConf.
+	// xochitl has no such setting of its own, so the key is whatever the
+	// toggle of the user writes and has no default.
+	MultipleMode    string        `yaml:"multiple_mode"`
+	MultipleModeKey string        `yaml:"multiple_mode_key"`
+	XochitlConf     string        `yaml:"xochitl_conf"`
+	SessionTimeout  time.Duration `yaml:"session_timeout"` // Idle time after which a batch is emitted
+
+	QueueDir          string        `yaml:"queue_dir"`           // Pending uploads, kept across restarts
+	RetryBase         time.Duration `yaml:"retry_base"`          // Delay before the first retry, doubled on each failure
+	RetryMax          time.Duration `yaml:"retry_max"`           // Longest delay between retries
+	UploadMaxAttempts int           `yaml:"upload_max_attempts"` // Attempts before a job is set aside, 0 for no limit
+
+	// Conversions run on Workers workers, JobQueueSize more may wait for one
+	// before the watcher blocks. A conversion running next to another one only
+	// starts with MinFreeMemoryMB of memory available.
+	Workers         int           `yaml:"workers"`
+	JobQueueSize    int           `yaml:"job_queue_size"`
+	JobTimeout      time.Duration `yaml:"job_timeout"` // Longest conversion of one document
+	MinFreeMemoryMB int           `yaml:"min_free_memory_mb"`
+
+	// ShutdownTimeout is how long queued conversions are still run on SIGTERM,
+	// 0 waiting for all of them; the screenshots of the others are picked up
+	// at the next start
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+
+	// Ledger lists the content hashes of the delivered screenshots, which the
+	// scan of the watched directories at start skips
+	Ledger string `yaml:"ledger"`
+
+	// Retention is what happens to a screenshot once delivered: delete,
+	// archive into ArchiveDir, keep_last to keep the KeepLast newest of each
+	// directory, or keep. Screenshots that failed are always left in place.
+	Retention  string `yaml:"retention"`
+	ArchiveDir string `yaml:"archive_dir"`
+	KeepLast   int    `yaml:"keep_last"`
+
+	// Logs go to LogFile, stderr when empty, rotated at LogMaxSizeMB keeping
+	// LogMaxFiles old files. LogLevel is debug, info, warn or error.
+	LogLevel     string `yaml:"log_level"`
+	LogFormat    string `yaml:"log_format"` // text or json
+	LogFile      string `yaml:"log_file"`
+	LogMaxSizeMB int    `yaml:"log_max_size_mb"`
+	LogMaxFiles  int    `yaml:"log_max_files"`
+
+	// APIListen is the host:port of the control API, disabled when empty. It
+	// has no authentication, so the host must be a loopback or the USB
+	// address unless APIPublic opts in to the other interfaces.
+	APIListen string `yaml:"api_listen"`
+	APIPublic bool   `yaml:"api_public"`
+
+	// Sink selects how documents are delivered, see SinkConfig. Documents
+	// written into a xochitl directory are loaded as XochitlReload says,
+	// after XochitlReloadDelay without new documents
+	Sink               SinkConfig    `yaml:"sink"`
+	Delivery           string        `yaml:"delivery"` // Older spelling of sink.type, web or xochitl
+	XochitlReload      string        `yaml:"xochitl_reload"`
+	XochitlReloadDelay time.Duration `yaml:"xochitl_reload_delay"`
+
+	// Rules replace dir_to_search and file_prefix with several watched
+	// directories, each with its own patterns, pipeline mode, folder and sink
+	Rules []WatchRule `yaml:"rules"`
+
+	docOpts rp.DocOptions
+	namer   *Namer
+	rules   []*WatchRule
+
+	// missingFolders is set when prepare found destination folders missing,
+	// which createFolders creates
+	missingFolders bool
+}
+
+// defaultConfig returns the settings used for every key the config file leaves out
+func defaultConfig() *Config {
+	return &Config{
+		DirToSearch:       "/home/root",
+		FilePrefix:        "Screenshot",
+		Pinned:            true,
+		NameTemplate:      DEFAULT_NAME_TEMPLATE,
+		DeterministicTime: DETERMINISTIC_TIME,
+		Device:            rp.DEVICE_RM2.Name,
+		Color:             "black",
+		Orientation:       ORIENTATION_PORTRAIT,
+		TileOverlap:       50,
+		UploadURL:         "http://" + USB_ADDRESS,
+		HTTPTimeout:       30 * time.Second,
+		XochitlDir:        rp.XOCHITL_DIR,
+		WriteTimeout:      10 * time.Second,
+
+		MultipleMode:   MULTIPLE_MODE_OFF,
+		XochitlConf:    "/home/root/.config/remarkable/xochitl.conf",
+		SessionTimeout: 5 * time.Minute,
+
+		QueueDir:  "/home/root/.local/share/drawj2d-go/queue",
+		RetryBase: 5 * time.Second,
+		RetryMax:  10 * time.Minute,
+
+		Workers:         2,
+		JobQueueSize:    16,
+		JobTimeout:      2 * time.Minute,
+		MinFreeMemoryMB: 200,
+		ShutdownTimeout: 30 * time.Second,
+		Ledger:          "/home/root/.local/share/drawj2d-go/processed.log",
+
+		Retention:  RETENTION_DELETE,
+		ArchiveDir: "/home/root/.local/share/drawj2d-go/archive",
+		KeepLast:   20,
+
+		LogLevel:     "info",
+		LogFormat:    LOG_FORMAT_TEXT,
+		LogMaxSizeMB: 1,
+		LogMaxFiles:  2,
+
+		Sink:               SinkConfig{Type: SINK_WEB},
+		XochitlReload:      XOCHITL_RELOAD_RESTART,
+		XochitlReloadDelay: 10 * time.Second,
+	}
+}
+
+// DefaultConfigPath returns $XDG_CONFIG_HOME/drawj2d-go/config.yaml, falling
+// back to ~/.config when XDG_CONFIG_HOME is not set
+func DefaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = "/home/root/.config"
+	}
+	return fp.Join(dir, "drawj2d-go", "config.yaml")
+}
+
+// ConfigOverrides holds config values given on the command line, keyed by YAML key
+type ConfigOverrides map[string]string
+
+// RegisterConfigFlags adds a -config flag and one flag per config key, named
+// after the key with dashes, e.g. -dir-to-search. List values are comma separated.
+func RegisterConfigFlags(fs *flag.FlagSet) (*string, ConfigOverrides) {
+	path := fs.String("config", DefaultConfigPath(), "path of the YAML config file")
+	overrides := make(ConfigOverrides)
+
+	forEachConfigKey(defaultConfig(), func(key string, field reflect.Value) {
+		usage := fmt.Sprintf("override %s from the config file", key)
+		set := func(value string) error {
+			if err := setConfigField(field, value); err != nil {
+				return err
+			}
+			overrides[key] = value
+			return nil
+		}
+		// Booleans may be given without a value, as in -tile
+		if field.Kind() == reflect.Bool {
+			fs.BoolFunc(strings.ReplaceAll(key, "_", "-"), usage, set)
+		} else {
+			fs.Func(strings.ReplaceAll(key, "_", "-"), usage, set)
+		}
+	})
+
+	return path, overrides
+}
+
+// LoadConfig reads the config file at path over the defaults, then applies the
+// DRAWJ2D_* environment variables and the command line overrides, and validates
+// the result. A missing file is only an error when it is not the default path.
+func LoadConfig(path string, overrides ConfigOverrides) (*Config, error) {
+	config := defaultConfig()
+
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("config %s: %w", path, err)
+		}
+	case errors.Is(err, os.ErrNotExist) && path == DefaultConfigPath():
+		// Defaults only
+	default:
+		return nil, fmt.Errorf("config: %w", err)
+	}
+
+	var overrideErr error
+	forEachConfigKey(config, func(key string, field reflect.Value) {
+		if value, ok := os.LookupEnv(ENV_PREFIX + strings.ToUpper(key)); ok {
+			if err := setConfigField(field, value); err != nil {
+				overrideErr = errors.Join(overrideErr, fmt.Errorf("%s%s: %w", ENV_PREFIX, strings.ToUpper(key), err))
+			}
+		}
+		if value, ok := overrides[key]; ok {
+			if err := setConfigField(field, value); err != nil {
+				overrideErr = errors.Join(overrideErr, fmt.Errorf("-%s: %w", strings.ReplaceAll(key, "_", "-"), err))
+			}
+		}
+	})
+	if overrideErr != nil {
+		return nil, overrideErr
+	}
+	if config.Delivery != "" && config.Sink.Type == SINK_WEB {
+		config.Sink.Type = config.Delivery
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// privateAPIHost tells whether the API host only answers this machine or
+// the computer at the other end of the USB cable
+func privateAPIHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && (ip.IsLoopback() || ip.Equal(net.ParseIP(USB_ADDRESS)))
+}
+
+// validate checks every setting and reports all the problems at once
+func (config *Config) validate() error {
+	var errs []error
+	invalid := func(key, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: "+format, append([]any{key}, args...)...))
+	}
+
+	if _, err := time.Parse(time.RFC3339, config.DeterministicTime); err != nil {
+		invalid("deterministic_time", "%v", err)
+	}
+	if config.PDF != "" {
+		if _, err := os.Stat(config.PDF); err != nil {
+			invalid("pdf", "%v", err)
+		}
+	}
+	if config.ExportDir != "" {
+		if info, err := os.Stat(config.ExportDir); err == nil && !info.IsDir() {
+			invalid("export_dir", "%s is not a directory", config.ExportDir)
+		}
+	}
+	if device, err := rp.ParseDevice(config.Device); err != nil {
+		invalid("device", "%v", err)
+	} else if _, err := device.ColorIndex(config.Color); err != nil {
+		invalid("color", "%v", err)
+	}
+	switch config.Orientation {
+	case ORIENTATION_PORTRAIT, ORIENTATION_LANDSCAPE, ORIENTATION_AUTO:
+	default:
+		invalid("orientation", "%q is not one of portrait, landscape, auto", config.Orientation)
+	}
+	if config.TileScale < 0 {
+		invalid("tile_scale", "must not be negative")
+	}
+	if limit := float64(config.device().Width / 2); config.TileOverlap < 0 || config.TileOverlap >= limit {
+		invalid("tile_overlap", "must be between 0 and %g", limit)
+	}
+	if _, err := NewNamer(config.NameTemplate); err != nil {
+		invalid("name_template", "%v", err)
+	}
+	if u, err := url.Parse(config.UploadURL); err != nil || u.Scheme == "" || u.Host == "" {
+		invalid("upload_url", "%q is not an absolute URL", config.UploadURL)
+	}
+	if config.HTTPTimeout <= 0 {
+		invalid("http_timeout", "must be positive")
+	}
+	if config.WriteTimeout <= 0 {
+		invalid("write_timeout", "must be positive")
+	}
+	switch config.MultipleMode {
+	case MULTIPLE_MODE_AUTO, MULTIPLE_MODE_ON, MULTIPLE_MODE_OFF:
+	default:
+		invalid("multiple_mode", "%q is not one of auto, on, off", config.MultipleMode)
+	}
+	if config.MultipleMode == MULTIPLE_MODE_AUTO && config.MultipleModeKey == "" {
+		invalid("multiple_mode_key", "must not be empty when multiple_mode is auto")
+	}
+	if config.SessionTimeout <= 0 {
+		invalid("session_timeout", "must be positive")
+	}
+	if config.QueueDir == "" {
+		invalid("queue_dir", "must not be empty")
+	}
+	if config.RetryBase <= 0 || config.RetryMax < config.RetryBase {
+		invalid("retry_base", "must be positive and not above retry_max")
+	}
+	if config.UploadMaxAttempts < 0 {
+		invalid("upload_max_attempts", "must not be negative")
+	}
+	if config.Workers < 1 {
+		invalid("workers", "must be at least 1")
+	}
+	if config.JobQueueSize < 0 {
+		invalid("job_queue_size", "must not be negative")
+	}
+	if config.JobTimeout <= 0 {
+		invalid("job_timeout", "must be positive")
+	}
+	if config.MinFreeMemoryMB < 0 {
+		invalid("min_free_memory_mb", "must not be negative")
+	}
+	if config.ShutdownTimeout < 0 {
+		invalid("shutdown_timeout", "must not be negative")
+	}
+	if config.Ledger == "" {
+		invalid("ledger", "must not be empty")
+	}
+	switch config.Retention {
+	case RETENTION_DELETE, RETENTION_KEEP:
+	case RETENTION_ARCHIVE:
+		if config.ArchiveDir == "" {
+			invalid("archive_dir", "must not be empty when retention is archive")
+		}
+	case RETENTION_KEEP_LAST:
+		if config.KeepLast < 1 {
+			invalid("keep_last", "must be at least 1 when retention is keep_last")
+		}
+	default:
+		invalid("retention", "%q is not one of delete, archive, keep_last, keep", config.Retention)
+	}
+	if _, err := parseLogLevel(config.LogLevel); err != nil {
+		invalid("log_level", "%v", err)
+	}
+	switch config.LogFormat {
+	case LOG_FORMAT_TEXT, LOG_FORMAT_JSON:
+	default:
+		invalid("log_format", "%q is not one of text, json", config.LogFormat)
+	}
+	if config.LogMaxSizeMB < 0 || config.LogMaxFiles < 0 {
+		invalid("log_max_size_mb", "and log_max_files must not be negative")
+	}
+	if config.APIListen != "" {
+		if host, _, err := net.SplitHostPort(config.APIListen); err != nil {
+			invalid("api_listen", "%v", err)
+		} else if !config.APIPublic && !privateAPIHost(host) {
+			invalid("api_listen", "%q is reachable from other machines, use 127.0.0.1 or %s, or set api_public", config.APIListen, USB_ADDRESS)
+		}
+	}
+	switch config.Delivery {
+	case "":
+	case SINK_WEB, SINK_XOCHITL:
+		if config.Sink.Type != config.Delivery {
+			invalid("delivery", "%q conflicts with sink_type %q", config.Delivery, config.Sink.Type)
+		}
+	default:
+		invalid("delivery", "%q is not one of web, xochitl", config.Delivery)
+	}
+	errs = append(errs, config.Sink.validate("sink")...)
+	if config.Sink.Type == SINK_XOCHITL {
+		dir := orDefault(config.Sink.Path, config.XochitlDir)
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			invalid("sink_path", "%q must be an existing directory for the xochitl sink", dir)
+		}
+	}
+	switch config.XochitlReload {
+	case XOCHITL_RELOAD_RESTART, XOCHITL_RELOAD_NONE:
+	default:
+		invalid("xochitl_reload", "%q is not one of restart, none", config.XochitlReload)
+	}
+	if config.XochitlReloadDelay < 0 {
+		invalid("xochitl_reload_delay", "must not be negative")
+	}
+	// Folders are looked up in the local library, which the ssh sink does not deliver to
+	switch {
+	case config.Folder != "" && config.Sink.Type == SINK_SSH:
+		invalid("folder", "cannot be used with the ssh sink, folders are resolved in the local xochitl_dir")
+	case config.Folder != "" && config.XochitlDir == "":
+		invalid("xochitl_dir", "is needed to resolve folder %q", config.Folder)
+	}
+	for i := range config.Rules {
+		rule := &config.Rules[i]
+		key := fmt.Sprintf("rules[%d]", i)
+		errs = append(errs, rule.validate(key)...)
+		sinkType := config.Sink.Type
+		if rule.Sink != nil {
+			sinkType = rule.Sink.Type
+		}
+		switch {
+		case sinkType == SINK_SSH && (rule.Folder != "" || config.Folder != "" && config.Sink.Type != SINK_SSH):
+			invalid(key+".folder", "cannot be used with the ssh sink, folders are resolved in the local xochitl_dir")
+		case rule.Folder != "" && config.XochitlDir == "":
+			invalid("xochitl_dir", "is needed to resolve folder %q of %s", rule.Folder, key)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateWatch checks the settings only the watcher needs, so one-shot
+// commands work on machines without the tablet directories
+func (config *Config) validateWatch() error {
+	var errs []error
+	if len(config.Rules) == 0 {
+		rule := config.watchRules()[0]
+		errs = append(errs, rule.validateDir("dir_to_search"))
+		if config.FilePrefix == "" {
+			errs = append(errs, fmt.Errorf("file_prefix: must not be empty"))
+		}
+	}
+	for i := range config.Rules {
+		errs = append(errs, config.Rules[i].validateDir(fmt.Sprintf("rules[%d].dir", i)))
+	}
+	return errors.Join(errs...)
+}
+
+// prepare resolves the settings that need the filesystem, such as the
+// destination folder, once the config is valid. It changes nothing on disk:
+// documents go to the library root until createFolders made the missing folders.
+func (config *Config) prepare() error {
+	config.missingFolders = false
+	opts, err := config.docOptions()
+	if err != nil {
+		return err
+	}
+	config.docOpts = opts
+
+	config.namer, err = NewNamer(config.NameTemplate)
+	if err != nil {
+		return err
+	}
+
+	config.rules = nil
+	for _, rule := range config.watchRules() {
+		if err := rule.prepare(config); err != nil {
+			return err
+		}
+		config.rules = append(config.rules, &rule)
+	}
+	return nil
+}
+
+// createFolders creates the destination folders prepare did not find and
+// restarts xochitl so that it shows them. Only the watcher calls it, once it
+// accepted the config.
+func (config *Config) createFolders() error {
+	if !config.missingFolders {
+		return nil
+	}
+	folders := []string{config.Folder}
+	for _, rule := range config.rules {
+		folders = append(folders, rule.Folder)
+	}
+	created := false
+	for _, folder := range folders {
+		_, made, err := rp.ResolveFolder(config.XochitlDir, folder)
+		if err != nil {
+			return err
+		}
+		created = created || made
+	}
+	if err := config.prepare(); err != nil {
+		return err
+	}
+	if created {
+		xochitl.loadFolders(config)
+	}
+	return nil
+}
+
+// lookupFolder resolves a destination folder without creating it, "" with
+// missingFolders set when it does not exist yet
+func (config *Config) lookupFolder(folder string) (string, error) {
+	parent, err := rp.LookupFolder(config.XochitlDir, folder)
+	if errors.Is(err, rp.ErrNoFolder) {
+		config.missingFolders = true
+		return "", nil
+	}
+	return parent, err
+}
+
+// device returns the tablet model of the config, the rM2 when it is unknown
+func (config *Config) device() rp.Device {
+	if device, ok := rp.Devices[config.Device]; ok {
+		return device
+	}
+	return rp.DEVICE_RM2
+}
+
+// docOptions resolves the library placement settings of the config
+func (config *Config) docOptions() (rp.DocOptions, error) {
+	opts := rp.DocOptions{
+		Pinned: config.Pinned,
+		Tags:   config.Tags,
+		Device: config.device(),
+	}
+	opts.AllPageTags = config.PageTags
+
+	parent, err := config.lookupFolder(config.Folder)
+	if err != nil {
+		return opts, err
+	}
+	opts.Parent = parent
+
+	if config.PDF != "" {
+		opts.PDF, err = os.ReadFile(config.PDF)
+		if err != nil {
+			return opts, fmt.Errorf("reading the reference PDF: %w", err)
+		}
+	}
+
+	return opts, nil
+}
+
+// forEachConfigKey calls fn with the YAML key and settable value of every
+// config field. Nested sections are flattened, sink.type becoming sink_type.
+func forEachConfigKey(config *Config, fn func(key string, field reflect.Value)) {
+	forEachField(reflect.ValueOf(config).Elem(), "", fn)
+}
+
+func forEachField(value reflect.Value, prefix string, fn func(key string, field reflect.Value)) {
+	for i := 0; i < value.NumField(); i++ {
+		key := value.Type().Field(i).Tag.Get("yaml")
+		if key == "" {
+			continue
+		}
+		field := value.Field(i)
+		switch {
+		case field.Kind() == reflect.Struct:
+			forEachField(field, prefix+key+"_", fn)
+		case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.Struct:
+			// Lists of sections, such as rules, are only read from the file
+		default:
+			fn(prefix+key, field)
+		}
+	}
+}
+
+// setConfigField parses raw into the config field according to its type
+func setConfigField(field reflect.Value, raw string) error {
+	switch field.Interface().(type) {
+	case string:
+		field.SetString(raw)
+	case bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", raw)
+		}
+		field.SetBool(b)
+	case int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", raw)
+		}
+		field.SetInt(int64(n))
+	case float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", raw)
+		}
+		field.SetFloat(f)
+	case time.Duration:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration", raw)
+		}
+		field.SetInt(int64(d))
+	case []string:
+		var list []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		field.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+	return nil
+}
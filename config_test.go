@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	fp "path/filepath"
+
+	rp "github.com/pragmatically-dev/PoC-drawj2d-port-go/remarkablepage"
+)
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	path := fp.Join(dir, "config.yaml")
+	os.WriteFile(path, []byte(`
+dir_to_search: `+dir+`
+file_prefix: Shot
+tags: [from-file]
+write_timeout: 300ms
+`), 0644)
+
+	t.Setenv(ENV_PREFIX+"FILE_PREFIX", "EnvShot")
+	t.Setenv(ENV_PREFIX+"PINNED", "false")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	_, overrides := RegisterConfigFlags(fs)
+	if err := fs.Parse([]string{"-file-prefix", "FlagShot", "-tags", "a, b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadConfig(path, overrides)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.FilePrefix != "FlagShot" || config.Pinned || config.WriteTimeout != 300*time.Millisecond {
+		t.Fatalf("unexpected config: %+v", config)
+	}
+	if strings.Join(config.Tags, "|") != "a|b" {
+		t.Fatalf("unexpected tags: %q", config.Tags)
+	}
+	if config.UploadURL != defaultConfig().UploadURL {
+		t.Fatalf("default upload_url lost: %q", config.UploadURL)
+	}
+}
+
+func TestLoadConfigValidation(t *testing.T) {
+	path := fp.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte(`
+dir_to_search: /does/not/exist
+file_prefix: ""
+upload_url: not-a-url
+`), 0644)
+
+	_, err := LoadConfig(path, nil)
+	if err == nil {
+		t.Fatal("expected validation errors")
+	}
+	config := defaultConfig()
+	config.DirToSearch, config.FilePrefix = "/does/not/exist", ""
+	err = errors.Join(err, config.validateWatch())
+	for _, key := range []string{"dir_to_search", "file_prefix", "upload_url"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("error does not mention %s: %v", key, err)
+		}
+	}
+
+	os.WriteFile(path, []byte("unknown_key: 1\n"), 0644)
+	if _, err := LoadConfig(path, nil); err == nil {
+		t.Fatal("expected an error for an unknown key")
+	}
+}
+
+func TestDeviceColors(t *testing.T) {
+	config := defaultConfig()
+	config.Device, config.Color = "paper_pro", "blue"
+	if err := config.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if opts, _ := config.docOptions(); opts.Device.Width != 1620 {
+		t.Errorf("document made for the %s", opts.Device.Name)
+	}
+
+	config.Device = "rm2"
+	if err := config.validate(); err == nil || !strings.Contains(err.Error(), "color") {
+		t.Errorf("blue ink accepted on the rM2: %v", err)
+	}
+	config.Device = "kindle"
+	err := config.validate()
+	if err == nil || !strings.Contains(err.Error(), "device") {
+		t.Errorf("unknown device accepted: %v", err)
+	}
+	if strings.Contains(err.Error(), "tile_overlap") {
+		t.Errorf("unknown device also rejects the default tile_overlap: %v", err)
+	}
+}
+
+func TestDeterministicIgnoresModTime(t *testing.T) {
+	dir := t.TempDir()
+	config := defaultConfig()
+	config.Deterministic = true
+
+	var stamps []time.Time
+	var ids []string
+	for i, name := range []string{"a.png", "b.png"} {
+		path := fp.Join(dir, name)
+		os.WriteFile(path, []byte("same contents"), 0644)
+		modTime := time.Now().Add(time.Duration(-i) * time.Hour)
+		os.Chtimes(path, modTime, modTime)
+
+		opts, err := config.optionsFor(config.docOpts, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stamps = append(stamps, opts.Now())
+		ids = append(ids, rp.SeededIDs(opts.IDSeed)())
+	}
+	if !stamps[0].Equal(stamps[1]) || ids[0] != ids[1] {
+		t.Errorf("stamps %v and IDs %v differ for the same contents", stamps, ids)
+	}
+	if want, _ := time.Parse(time.RFC3339, DETERMINISTIC_TIME); !stamps[0].Equal(want) {
+		t.Errorf("stamped %v, want %v", stamps[0], want)
+	}
+}
+
+func TestDeliveryKeyStillSelectsTheSink(t *testing.T) {
+	dir := t.TempDir()
+	path := fp.Join(dir, "config.yaml")
+	os.WriteFile(path, []byte("delivery: xochitl\nxochitl_dir: "+dir+"\n"), 0644)
+	config, err := LoadConfig(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Sink.Type != SINK_XOCHITL {
+		t.Fatalf("sink type = %q, want xochitl", config.Sink.Type)
+	}
+
+	os.WriteFile(path, []byte("delivery: xochitl\nsink:\n  type: dir\n  path: "+dir+"\n"), 0644)
+	if _, err := LoadConfig(path, nil); err == nil || !strings.Contains(err.Error(), "delivery") {
+		t.Fatalf("expected a delivery conflict, got %v", err)
+	}
+}
+
+func TestAPIListenStaysPrivate(t *testing.T) {
+	config := defaultConfig()
+	for _, listen := range []string{"127.0.0.1:8790", "localhost:8790", "[::1]:8790", "10.11.99.1:8790"} {
+		config.APIListen = listen
+		if err := config.validate(); err != nil {
+			t.Errorf("%s rejected: %v", listen, err)
+		}
+	}
+	for _, listen := range []string{":8790", "0.0.0.0:8790", "192.168.1.20:8790"} {
+		config.APIListen = listen
+		if err := config.validate(); err == nil || !strings.Contains(err.Error(), "api_public") {
+			t.Errorf("%s accepted: %v", listen, err)
+		}
+	}
+
+	config.APIPublic = true
+	if err := config.validate(); err != nil {
+		t.Errorf("api_public not honoured: %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"strings"
+	"time"
+)
+
+// EXIF tags read by exifDate
+const (
+	EXIF_IFD_POINTER        = 0x8769
+	EXIF_DATE_TIME          = 0x0132
+	EXIF_DATE_TIME_ORIGINAL = 0x9003
+)
+
+// EXIF_LAYOUT is the layout of the EXIF dates, in the camera's local time
+const EXIF_LAYOUT = "2006:01:02 15:04:05"
+
+// exifDate returns when a JPEG or PNG picture was taken according to its
+// EXIF data, falling back to the date it was last edited
+func exifDate(path string) (time.Time, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return time.Time{}, false
+	}
+	tiff := exifBlock(data)
+	if tiff == nil {
+		return time.Time{}, false
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return time.Time{}, false
+	}
+
+	ifd0 := readIFD(tiff, order, order.Uint32(tiff[4:8]))
+	dates := []string{ifd0[EXIF_DATE_TIME]}
+	if pointer, ok := ifd0[EXIF_IFD_POINTER]; ok && len(pointer) == 4 {
+		exif := readIFD(tiff, order, order.Uint32([]byte(pointer)))
+		dates = append([]string{exif[EXIF_DATE_TIME_ORIGINAL]}, dates...)
+	}
+	for _, date := range dates {
+		if taken, err := time.ParseInLocation(EXIF_LAYOUT, strings.TrimRight(date, "\x00 "), time.Local); err == nil {
+			return taken, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// exifBlock returns the TIFF structure holding the EXIF data of a JPEG (APP1
+// segment) or PNG (eXIf chunk), nil when there is none
+func exifBlock(data []byte) []byte {
+	var block []byte
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8}):
+		for i := 2; i+4 <= len(data) && data[i] == 0xff; {
+			marker, size := data[i+1], int(binary.BigEndian.Uint16(data[i+2:]))
+			if marker == 0xda || size < 2 || i+2+size > len(data) {
+				break // Image data follows, or a corrupt length
+			}
+			segment := data[i+4 : i+2+size]
+			if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+				block = segment[6:]
+				break
+			}
+			i += 2 + size
+		}
+	case bytes.HasPrefix(data, pngSignature):
+		for i := len(pngSignature); i+8 <= len(data); {
+			size := int(binary.BigEndian.Uint32(data[i:]))
+			if size < 0 || size > len(data) || i+12+size > len(data) {
+				break
+			}
+			if string(data[i+4:i+8]) == "eXIf" {
+				block = data[i+8 : i+8+size]
+				break
+			}
+			i += 12 + size
+		}
+	}
+	if len(block) < 8 {
+		return nil
+	}
+	return block
+}
+
+// readIFD returns the values of the ASCII and LONG entries of the image file
+// directory at offset, LONG values as their 4 raw bytes
+func readIFD(tiff []byte, order binary.ByteOrder, offset uint32) map[uint16]string {
+	entries := make(map[uint16]string)
+	if uint64(offset)+2 > uint64(len(tiff)) {
+		return entries
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		entry := int(offset) + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		tag, kind, n := order.Uint16(tiff[entry:]), order.Uint16(tiff[entry+2:]), order.Uint32(tiff[entry+4:])
+		value := tiff[entry+8 : entry+12]
+		switch kind {
+		case 2: // ASCII, inline up to 4 bytes
+			if n > 4 {
+				start := order.Uint32(value)
+				if uint64(start)+uint64(n) > uint64(len(tiff)) {
+					continue
+				}
+				value = tiff[start : start+n]
+			} else {
+				value = value[:n]
+			}
+		case 4: // LONG
+		default:
+			continue
+		}
+		entries[tag] = string(value)
+	}
+	return entries
+}
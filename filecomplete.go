@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// COMPLETION_POLL is how often a new file is checked while it is being written
+const COMPLETION_POLL = 50 * time.Millisecond
+
+// stablePolls is how many polls the size of a file without an end marker
+// must stay the same before it is considered complete
+const stablePolls = 4
+
+var (
+	pngSignature = []byte("\x89PNG\r\n\x1a\n")
+	pngIEND      = []byte("\x00\x00\x00\x00IEND\xae\x42\x60\x82")
+	jpegSOI      = []byte("\xff\xd8")
+	jpegEOI      = []byte("\xff\xd9")
+)
+
+// fileState is what one poll learnt about a file being written
+type fileState struct {
+	size      int64
+	hasMarker bool // The format ends with a known marker
+	complete  bool // The marker has been written
+}
+
+// waitForCompleteFile returns as soon as the file at path has been fully
+// written, or an error after timeout. PNG and JPEG files are complete once
+// their end marker (IEND chunk, EOI) is in place, so a partial file is never
+// read; other files once their size stops changing.
+func waitForCompleteFile(path string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	lastSize, stable := int64(-1), 0
+
+	for {
+		state, err := pollFile(path)
+		switch {
+		case err != nil && !os.IsNotExist(err):
+			return err
+		case state.complete:
+			return nil
+		case err == nil && !state.hasMarker && state.size > 0 && state.size == lastSize:
+			stable++
+			if stable >= stablePolls {
+				return nil
+			}
+		default:
+			stable = 0
+		}
+		lastSize = state.size
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s still incomplete after %s (%d bytes)", path, timeout, state.size)
+		}
+		time.Sleep(COMPLETION_POLL)
+	}
+}
+
+// pollFile reads the size, signature and tail of the file
+func pollFile(path string) (fileState, error) {
+	var state fileState
+
+	file, err := os.Open(path)
+	if err != nil {
+		return state, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return state, err
+	}
+	state.size = info.Size()
+
+	head := make([]byte, len(pngSignature))
+	n, _ := io.ReadFull(file, head)
+	head = head[:n]
+
+	var marker []byte
+	switch {
+	case bytes.HasPrefix(head, pngSignature):
+		marker = pngIEND
+	case bytes.HasPrefix(head, jpegSOI):
+		marker = jpegEOI
+	default:
+		return state, nil
+	}
+	state.hasMarker = true
+
+	if state.size < int64(len(head)+len(marker)) {
+		return state, nil
+	}
+	tail := make([]byte, len(marker))
+	if _, err := file.ReadAt(tail, state.size-int64(len(marker))); err != nil {
+		return state, err
+	}
+	state.complete = bytes.Equal(tail, marker)
+	return state, nil
+}
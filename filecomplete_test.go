@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	fp "path/filepath"
+)
+
+func TestWaitForCompleteFilePNG(t *testing.T) {
+	path := fp.Join(t.TempDir(), "Screenshot.png")
+	png, err := os.ReadFile("images/Screenshot.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Written in two halves, the second one late
+	os.WriteFile(path, png[:len(png)/2], 0644)
+	done := make(chan error, 1)
+	go func() { done <- waitForCompleteFile(path, 2*time.Second) }()
+
+	time.Sleep(300 * time.Millisecond)
+	select {
+	case err := <-done:
+		t.Fatalf("returned on a partial file: %v", err)
+	default:
+	}
+
+	file, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	file.Write(png[len(png)/2:])
+	file.Close()
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	os.WriteFile(path, png[:len(png)-1], 0644)
+	if err := waitForCompleteFile(path, 200*time.Millisecond); err == nil {
+		t.Fatal("expected a timeout on a truncated PNG")
+	}
+}
+
+func TestWaitForCompleteFileWithoutMarker(t *testing.T) {
+	path := fp.Join(t.TempDir(), "notes.txt")
+	os.WriteFile(path, []byte("plain text"), 0644)
+
+	start := time.Now()
+	if err := waitForCompleteFile(path, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < stablePolls*COMPLETION_POLL {
+		t.Fatal("returned before the size settled")
+	}
+}
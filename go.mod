@@ -6,6 +6,10 @@ require (
 	github.com/fsnotify/fsnotify v1.7.0
 	github.com/google/uuid v1.6.0
 	go.uber.org/automaxprocs v1.5.3
+	gopkg.in/yaml.v3 v3.0.1
 )
 
-require golang.org/x/sys v0.4.0 // indirect
+require (
+	github.com/kr/text v0.2.0 // indirect
+	golang.org/x/sys v0.4.0 // indirect
+)
@@ -1,6 +1,8 @@
 #!/bin/bash
 
 BIN_DIR="/home/root/.local/bin/drawj2d-go"
+CONFIG_DIR="/home/root/.config/drawj2d-go"
+CONFIG_FILE="$CONFIG_DIR/config.yaml"
 SYSTEMD_DIR="/etc/systemd/system"
 
 CLIENT_SRC="./drawj2d-go"
@@ -15,13 +17,15 @@ After=home.mount
 ExecStart=$BIN_DIR/drawj2d-go
 Restart=on-failure
 RestartSec=5
-Enviroment="HOME=/home/root"
+ExecReload=/bin/kill -HUP \$MAINPID
+TimeoutStopSec=60
+Environment="HOME=/home/root"
 
 [Install]
 WantedBy=multi-user.target"
 
-mkdir "$BIN_DIR"
-mkdir "$CONFIG_DIR"
+mkdir -p "$BIN_DIR"
+mkdir -p "$CONFIG_DIR"
 
 
 cp "$CLIENT_SRC" "$BIN_DIR"
@@ -34,6 +38,17 @@ fi
 echo "Files copied successfully."
 
 
+# Keep the user's settings across reinstalls
+if [ ! -f "$CONFIG_FILE" ]; then
+  cat > "$CONFIG_FILE" <<EOF
+dir_to_search: /home/root
+file_prefix: Screenshot
+pinned: true
+EOF
+  echo "Default config written to $CONFIG_FILE"
+fi
+
+
 echo "$SERVICE_CONTENT" > "$SERVICE_FILE"
 mv "$SERVICE_FILE" "$SYSTEMD_DIR"
 if [ $? -ne 0 ]; then
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	fp "path/filepath"
+)
+
+// LEDGER_MAX is the number of entries compaction aims for. Only the entries
+// of screenshots no longer on disk are dropped, so a kept screenshot is never
+// uploaded again however many were delivered since.
+const LEDGER_MAX = 10000
+
+// ledger remembers the delivered screenshots, set up by AppStart
+var ledger *Ledger
+
+// Ledger records the content hash of every delivered screenshot, one
+// "<sha256> <unix time> <path>" line each, so that a screenshot still on disk
+// after a crash or restart is not uploaded twice
+type Ledger struct {
+	path string
+
+	mu     sync.Mutex
+	hashes map[string]bool
+	paths  map[string]ledgerEntry // Latest entry by path
+}
+
+// ledgerEntry is one delivery of the ledger
+type ledgerEntry struct {
+	hash string
+	time int64
+}
+
+// parseLedgerLine splits a ledger line, ok is false for a torn one
+func parseLedgerLine(line string) (hash string, entry ledgerEntry, path string, ok bool) {
+	fields := strings.SplitN(line, " ", 3)
+	if len(fields) != 3 || len(fields[0]) != sha256.Size*2 {
+		return "", entry, "", false
+	}
+	unix, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return "", entry, "", false
+	}
+	return fields[0], ledgerEntry{fields[0], unix}, fields[2], true
+}
+
+// OpenLedger loads the ledger at path, compacting it when it grew past LEDGER_MAX
+func OpenLedger(path string) (*Ledger, error) {
+	if err := os.MkdirAll(fp.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("creating ledger: %w", err)
+	}
+	l := &Ledger{path: path, hashes: make(map[string]bool), paths: make(map[string]ledgerEntry)}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if _, _, _, ok := parseLedgerLine(scanner.Text()); ok {
+			lines = append(lines, scanner.Text())
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(lines) > LEDGER_MAX {
+		// Drop the oldest entries of the screenshots that are gone
+		excess := len(lines) - LEDGER_MAX
+		kept := lines[:0]
+		for _, line := range lines {
+			_, _, source, _ := parseLedgerLine(line)
+			if excess > 0 {
+				if _, err := os.Lstat(source); errors.Is(err, os.ErrNotExist) {
+					excess--
+					continue
+				}
+			}
+			kept = append(kept, line)
+		}
+		lines = kept
+		if err := writeFileSynced(path, []byte(strings.Join(lines, "\n")+"\n")); err != nil {
+			return nil, err
+		}
+	}
+	for _, line := range lines {
+		hash, entry, source, _ := parseLedgerLine(line)
+		l.hashes[hash] = true
+		l.paths[source] = entry
+	}
+	return l, nil
+}
+
+// Contains tells whether a screenshot with this hash was delivered
+func (l *Ledger) Contains(hash string) bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.hashes[hash]
+}
+
+// Record appends the screenshot at path to the ledger
+func (l *Ledger) Record(path string) error {
+	if l == nil {
+		return nil
+	}
+	hash, err := hashFile(path)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.hashes[hash] && l.paths[path].hash == hash {
+		return nil
+	}
+
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	entry := ledgerEntry{hash, time.Now().Unix()}
+	if _, err := fmt.Fprintf(file, "%s %d %s\n", hash, entry.time, path); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	l.hashes[hash] = true
+	l.paths[path] = entry
+	return nil
+}
+
+// Delivered tells whether the screenshot at path was delivered. A file left
+// unchanged since the ledger recorded it at this path is not hashed again.
+func (l *Ledger) Delivered(path string, info fs.FileInfo) bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	entry, ok := l.paths[path]
+	l.mu.Unlock()
+	if ok && info.ModTime().Unix() < entry.time {
+		return true
+	}
+
+	hash, err := hashFile(path)
+	return err == nil && l.Contains(hash)
+}
+
+// hashFile returns the hex SHA-256 of the file contents
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
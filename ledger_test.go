@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	fp "path/filepath"
+)
+
+func TestLedgerSurvivesRestarts(t *testing.T) {
+	dir := t.TempDir()
+	shot := writeScreenshot(t, dir, "Screenshot.png")
+	hash, err := hashFile(shot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := OpenLedger(fp.Join(dir, "state", "processed.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Contains(hash) {
+		t.Fatal("empty ledger contains the screenshot")
+	}
+	if err := l.Record(shot); err != nil {
+		t.Fatal(err)
+	}
+
+	// A torn line left by a power loss is ignored
+	f, _ := os.OpenFile(fp.Join(dir, "state", "processed.log"), os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString("0123")
+	f.Close()
+
+	reopened, err := OpenLedger(fp.Join(dir, "state", "processed.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reopened.Contains(hash) {
+		t.Error("delivered screenshot forgotten after a restart")
+	}
+}
+
+func TestPickUpScreenshotsSkipsDelivered(t *testing.T) {
+	var err error
+	ledger, err = OpenLedger(fp.Join(t.TempDir(), "processed.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ledger = nil })
+
+	config, _ := startProcessing(t, testQueueConfig(), emptyPage, func(string, []byte, string) error { return nil })
+
+	delivered := writeScreenshot(t, config.DirToSearch, "Screenshot_1.png")
+	ledger.Record(delivered)
+	os.WriteFile(fp.Join(config.DirToSearch, "Screenshot_2.png"), append(append([]byte{}, pngSignature...), append([]byte("other"), pngIEND...)...), 0644)
+
+	pickedUp := pickUpScreenshots(context.Background(), config)
+	if len(pickedUp) != 1 || pickedUp[delivered] {
+		t.Errorf("picked up %v, want only Screenshot_2.png", pickedUp)
+	}
+}
+
+func TestLedgerCompactionKeepsScreenshotsOnDisk(t *testing.T) {
+	dir := t.TempDir()
+	kept := writeScreenshot(t, dir, "Screenshot.png")
+	hash, _ := hashFile(kept)
+
+	var lines strings.Builder
+	fmt.Fprintf(&lines, "%s 1 %s\n", hash, kept)
+	for i := 0; i <= LEDGER_MAX; i++ {
+		fmt.Fprintf(&lines, "%064x 2 %s\n", i+1, fp.Join(dir, fmt.Sprintf("gone %d.png", i)))
+	}
+	path := fp.Join(dir, "processed.log")
+	os.WriteFile(path, []byte(lines.String()), 0644)
+
+	l, err := OpenLedger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.Contains(hash) {
+		t.Error("compaction dropped a screenshot still on disk")
+	}
+	data, _ := os.ReadFile(path)
+	if n := strings.Count(string(data), "\n"); n != LEDGER_MAX {
+		t.Errorf("compacted to %d entries, want %d", n, LEDGER_MAX)
+	}
+}
+
+func TestLedgerDeliveredSkipsUnchangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	l, err := OpenLedger(fp.Join(dir, "processed.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	shot := writeScreenshot(t, dir, "Screenshot.png")
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(shot, old, old)
+	l.Record(shot)
+
+	// Rewritten in place with its old time: trusted without hashing
+	os.WriteFile(shot, []byte("rewritten"), 0644)
+	os.Chtimes(shot, old, old)
+	info, _ := os.Stat(shot)
+	if !l.Delivered(shot, info) {
+		t.Error("unchanged screenshot not seen as delivered")
+	}
+
+	// Modified since: its content decides
+	os.Chtimes(shot, time.Now().Add(time.Hour), time.Now().Add(time.Hour))
+	info, _ = os.Stat(shot)
+	if l.Delivered(shot, info) {
+		t.Error("new content at a delivered path seen as delivered")
+	}
+}
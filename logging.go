@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	fp "path/filepath"
+)
+
+// Values of the log_format setting
+const (
+	LOG_FORMAT_TEXT = "text"
+	LOG_FORMAT_JSON = "json"
+)
+
+// logLevel is the level of the default logger, changed at runtime by config
+// reloads and the control API
+var logLevel = new(slog.LevelVar)
+
+// logOutput is the log file in use, nil when logging to stderr
+var logOutput *rotatingFile
+
+// parseLogLevel reads debug, info, warn or error
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return level, fmt.Errorf("%q is not one of debug, info, warn, error", s)
+	}
+	return level, nil
+}
+
+// configureLogging points the default logger, and with it the log package,
+// at the output and format of the config
+func configureLogging(config *Config) error {
+	level, err := parseLogLevel(config.LogLevel)
+	if err != nil {
+		return err
+	}
+	logLevel.Set(level)
+
+	// The previous file is closed once the new handler is in place
+	var output io.Writer = os.Stderr
+	previous := logOutput
+	switch {
+	case config.LogFile == "":
+		logOutput = nil
+	case logOutput != nil && logOutput.path == config.LogFile:
+		logOutput.configure(int64(config.LogMaxSizeMB)<<20, config.LogMaxFiles)
+		output, previous = logOutput, nil
+	default:
+		file, err := openRotatingFile(config.LogFile, int64(config.LogMaxSizeMB)<<20, config.LogMaxFiles)
+		if err != nil {
+			return err
+		}
+		logOutput, output = file, file
+	}
+
+	opts := &slog.HandlerOptions{Level: logLevel}
+	var handler slog.Handler = slog.NewTextHandler(output, opts)
+	if config.LogFormat == LOG_FORMAT_JSON {
+		handler = slog.NewJSONHandler(output, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+	if previous != nil {
+		previous.Close()
+	}
+	return nil
+}
+
+// rotatingFile is a log file renamed to path.1 once it reaches maxSize, the
+// older files shifting up to path.<maxFiles>, so logs never use more than
+// about (maxFiles+1)*maxSize of the tablet's storage
+type rotatingFile struct {
+	path string
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	maxSize  int64
+	maxFiles int
+}
+
+func openRotatingFile(path string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	if err := os.MkdirAll(fp.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	rf := &rotatingFile{path: path}
+	rf.configure(maxSize, maxFiles)
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) configure(maxSize int64, maxFiles int) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.maxSize, rf.maxFiles = maxSize, maxFiles
+}
+
+func (rf *rotatingFile) open() error {
+	file, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	rf.file, rf.size = file, info.Size()
+	return nil
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.file == nil {
+		// A logger made before the last reload still holds the closed file
+		return os.Stderr.Write(p)
+	}
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			// Keep logging to the full file rather than losing the records
+			fmt.Fprintln(os.Stderr, "drawj2d-go: rotating the log:", err)
+		}
+	}
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// rotate shifts path.N to path.N+1, dropping the oldest, and starts a new file
+func (rf *rotatingFile) rotate() error {
+	rf.file.Close()
+
+	os.Remove(fmt.Sprintf("%s.%d", rf.path, rf.maxFiles))
+	for i := rf.maxFiles - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+	}
+	if rf.maxFiles > 0 {
+		os.Rename(rf.path, rf.path+".1")
+	} else {
+		os.Remove(rf.path)
+	}
+	return rf.open()
+}
+
+func (rf *rotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
+
+// shortID shortens a job ID for the logs
+func shortID(id string) string {
+	id, _, _ = strings.Cut(id, "-")
+	return id
+}
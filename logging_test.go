@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	fp "path/filepath"
+)
+
+func TestRotatingFileKeepsMaxFiles(t *testing.T) {
+	path := fp.Join(t.TempDir(), "logs", "drawj2d-go.log")
+	rf, err := openRotatingFile(path, 100, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+
+	line := strings.Repeat("x", 39) + "\n"
+	for i := 0; i < 20; i++ {
+		rf.Write([]byte(line))
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > 100 {
+			t.Errorf("%s is %d bytes, above the maximum", name, info.Size())
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("more old files kept than log_max_files")
+	}
+}
+
+func TestConfigureLoggingJSONToFile(t *testing.T) {
+	t.Cleanup(func() {
+		configureLogging(defaultConfig())
+	})
+
+	config := defaultConfig()
+	config.LogFormat = LOG_FORMAT_JSON
+	config.LogLevel = "warn"
+	config.LogFile = fp.Join(t.TempDir(), "drawj2d-go.log")
+	if err := configureLogging(config); err != nil {
+		t.Fatal(err)
+	}
+
+	job := &uploadJob{ID: "0f8fad5b-d9cb-469f-a165-70867728950e", Job: "7c9e6679-7425-40de-944b-e07fc1f90ae7"}
+	job.logger().Info("not logged at warn")
+	job.logger().Warn("upload failed", "attempt", 2)
+
+	data, err := os.ReadFile(config.LogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	if len(lines) != 1 {
+		t.Fatalf("logged %d lines, want 1: %s", len(lines), data)
+	}
+	var record map[string]any
+	if err := json.Unmarshal(lines[0], &record); err != nil {
+		t.Fatal(err)
+	}
+	if record["upload"] != "0f8fad5b" || record["job"] != "7c9e6679" || record["msg"] != "upload failed" {
+		t.Errorf("record = %v", record)
+	}
+}
+
+func TestReloadKeepsEarlierLoggersWriting(t *testing.T) {
+	t.Cleanup(func() {
+		configureLogging(defaultConfig())
+	})
+
+	dir := t.TempDir()
+	config := defaultConfig()
+	config.LogFile = fp.Join(dir, "first.log")
+	if err := configureLogging(config); err != nil {
+		t.Fatal(err)
+	}
+	first := logOutput
+	config.LogFile = fp.Join(dir, "second.log")
+	if err := configureLogging(config); err != nil {
+		t.Fatal(err)
+	}
+
+	// A record from a handler made before the reload reaches stderr
+	if _, err := first.Write([]byte("late record\n")); err != nil {
+		t.Errorf("write to the replaced log file: %v", err)
+	}
+}
@@ -1,198 +1,516 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
+	"context"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"mime/multipart"
 	"net/http"
 	"os"
-	"runtime"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	fp "path/filepath"
-	"strings"
 
 	"github.com/fsnotify/fsnotify"
 	rp "github.com/pragmatically-dev/PoC-drawj2d-port-go/remarkablepage"
 	_ "go.uber.org/automaxprocs"
 )
 
-// Config structure to hold YAML configuration
-type Config struct {
-	DirToSearch string `yaml:"dir_to_search"`
-	FilePrefix  string `yaml:"file_prefix"`
+// webTransport keeps the connections to the web interface across clients
+var webTransport = &http.Transport{
+	MaxIdleConns:       10,
+	IdleConnTimeout:    90 * time.Second,
+	DisableCompression: true,
+	WriteBufferSize:    1 << 21,
+	ReadBufferSize:     1 << 21,
 }
 
-var httpClient = &http.Client{
-	Timeout: time.Second * 30,
-	Transport: &http.Transport{
-		MaxIdleConns:       10,
-		IdleConnTimeout:    90 * time.Second,
-		DisableCompression: true,
-		WriteBufferSize:    1 << 21,
-		ReadBufferSize:     1 << 21,
-	},
+// httpClient is the client of the uploads. A config reload swaps in a new
+// one rather than changing the timeout of the one uploads may be using.
+var httpClient atomic.Pointer[http.Client]
+
+func init() {
+	httpClient.Store(newHTTPClient(30 * time.Second))
+}
+
+func newHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: webTransport}
 }
 
-func isMultipleModeActive() bool {
-	var configText string
-	// Open the file
-	file, err := os.Open("/home/root/.config/remarkable/xochitl.conf")
+// postToLocalWebInterface sends an .rmdoc to the USB web interface into the
+// parent folder and fails unless the upload is acknowledged
+func postToLocalWebInterface(client *http.Client, url string, name string, rmdoc []byte, parent string) error {
+	// The web interface uploads into the folder that was browsed last
+	if err := selectFolder(client, url, parent); err != nil {
+		return fmt.Errorf("selecting the destination folder: %w", err)
+	}
+
+	var requestBody bytes.Buffer
+	writer := multipart.NewWriter(&requestBody)
+
+	part, err := writer.CreateFormFile("file", name)
 	if err != nil {
-		fmt.Println("Error opening file:", err)
-		return false
+		return fmt.Errorf("creating the form file field: %w", err)
 	}
-	defer file.Close()
 
-	// Create a new scanner for the file
-	scanner := bufio.NewScanner(file)
+	part.Write(rmdoc)
 
-	// Read and skip the first two lines
-	for i := 0; i < 2; i++ {
-		if !scanner.Scan() {
-			fmt.Println("The file has less than 3 lines.")
-			return false
-		}
+	err = writer.Close()
+	if err != nil {
+		return fmt.Errorf("closing the writer: %w", err)
 	}
 
-	// Read the third line
-	if scanner.Scan() {
-		configText = scanner.Text()
-	} else {
-		fmt.Println("The file has less than 3 lines.")
+	req, err := http.NewRequest("POST", url+"/upload", &requestBody)
+	if err != nil {
+		return fmt.Errorf("creating the request: %w", err)
 	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
 
-	// Check for errors during scanning
-	if err := scanner.Err(); err != nil {
-		fmt.Println("Error reading file:", err)
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending the request: %w", err)
 	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
 
-	configText = strings.Split(configText, "=")[0]
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("upload rejected with status %s", resp.Status)
+	}
+	return nil
+}
 
-	return configText == "true"
+// selectFolder browses the web interface to the given folder, "" being the root
+func selectFolder(client *http.Client, url, parent string) error {
+	resp, err := client.Post(url+"/documents/"+parent, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
 }
 
-func postToLocalWebInterface(rmDocPath string, rmdocbuff *bytes.Buffer, filepath string) {
+// documentOptions returns the options of the document converted from filepath
+func (config *Config) documentOptions(filepath string) (rp.DocOptions, error) {
+	return config.optionsFor(config.docOpts, filepath)
+}
 
-	defer func(fpt string) {
-		go deleteFile(fpt)
-		runtime.GC()
-	}(filepath)
+// DETERMINISTIC_TIME stamps deterministic documents by default, the earliest
+// time a zip entry can hold
+const DETERMINISTIC_TIME = "1980-01-01T00:00:00Z"
 
-	url := "http://10.11.99.1"
-	var requestBody bytes.Buffer
-	writer := multipart.NewWriter(&requestBody)
+// optionsFor returns opts for the document converted from filepath, derived
+// from its contents when the config is deterministic
+func (config *Config) optionsFor(opts rp.DocOptions, filepath string) (rp.DocOptions, error) {
+	if !config.Deterministic {
+		return opts, nil
+	}
 
-	part, err := writer.CreateFormFile("file", fp.Base(rmDocPath))
+	stamp, err := time.Parse(time.RFC3339, config.DeterministicTime)
+	if err != nil {
+		return opts, err
+	}
+	input, err := os.ReadFile(filepath)
 	if err != nil {
-		fmt.Println("Error creating the form file field:", err)
-		return
+		return opts, err
 	}
 
-	part.Write(rmdocbuff.Bytes())
+	return opts.Deterministic(input, stamp), nil
+}
 
-	err = writer.Close()
-	if err != nil {
-		fmt.Println("Error closing the writer:", err)
-		return
+// Values of the orientation setting
+const (
+	ORIENTATION_PORTRAIT  = "portrait"
+	ORIENTATION_LANDSCAPE = "landscape"
+	ORIENTATION_AUTO      = "auto" // Landscape for images wider than high
+)
+
+// landscape tells whether the document made from the image, its first one,
+// has landscape pages
+func (config *Config) landscape(imagePath string) bool {
+	switch config.Orientation {
+	case ORIENTATION_LANDSCAPE:
+		return true
+	case ORIENTATION_AUTO:
+		wide, err := rp.ImageIsWide(imagePath)
+		if err != nil {
+			slog.Warn("cannot read the image size, using portrait", "path", imagePath, "err", err)
+		}
+		return wide
 	}
+	return false
+}
 
-	req, err := http.NewRequest("POST", url+"/upload", &requestBody)
-	if err != nil {
-		fmt.Println("Error creating the request:", err)
-		return
+// layout places the pages of a document on the device of the config
+func (config *Config) layout(landscape bool) rp.Layout {
+	return rp.Layout{
+		Device:    config.device(),
+		Color:     config.Color,
+		Landscape: landscape,
+		Tile:      config.Tile,
+		Scale:     float32(config.TileScale),
+		Overlap:   float32(config.TileOverlap),
 	}
-	req.Header.Set("Content-Type", writer.FormDataContentType())
+}
 
-	resp, err := httpClient.Do(req) // Usa el cliente HTTP reutilizable
+// convertImage converts one image into its page, or its tiles when the config
+// tiles the images
+func (config *Config) convertImage(imagePath string, mode rp.Mode, landscape bool) ([][]byte, rp.ConversionStats, error) {
+	return rp.ConvertImageLayout(imagePath, mode, config.layout(landscape))
+}
+
+// watchDirs adds the directories the config needs to the watcher and removes
+// the ones it no longer needs. Nothing is removed when a directory cannot be added.
+func watchDirs(watcher *fsnotify.Watcher, config *Config) error {
+	dirs, err := config.watchedDirs()
 	if err != nil {
-		fmt.Println("Error sending the request:", err)
-		return
+		return err
 	}
-	defer resp.Body.Close()
 
+	wanted := make(map[string]bool)
+	for _, dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			return fmt.Errorf("cannot watch %s: %w", dir, err)
+		}
+		wanted[dir] = true
+	}
+	for _, dir := range watcher.WatchList() {
+		if !wanted[dir] {
+			watcher.Remove(dir)
+		}
+	}
+	return nil
 }
 
-func singleConversionMode(filepath string) {
-	rmData := rp.LaplacianEdgeDetection(filepath)
-	rmFile := rp.GetFileNameWithoutExtension(filepath)
-	var multiData [][]byte
-	multiData = append(multiData, rmData)
-	rmDocBuff, rmDocPath := rp.CreateRmDoc(rmFile, multiData)
-	if rmDocBuff == nil {
-		fmt.Println("Error al crear zip en memoria:")
-		return
+// pickUpScreenshots submits the screenshots that arrived while the service
+// was down or paused, leaving out the ones skip lists, the ones in a job or
+// in the upload queue and the ones the ledger lists as delivered, and returns
+// the submitted paths
+func pickUpScreenshots(ctx context.Context, config *Config, skip ...string) map[string]bool {
+	submitted := make(map[string]bool)
+	taken := uploads.Sources()
+	for source := range processing.Sources() {
+		taken[source] = true
+	}
+	for _, source := range skip {
+		taken[source] = true
+	}
+
+	dirs, err := config.watchedDirs()
+	if err != nil {
+		slog.Error("cannot list the watched directories", "err", err)
+	}
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			slog.Error("cannot read the watched directory", "dir", dir, "err", err)
+			continue
+		}
+		for _, entry := range entries {
+			if ctx.Err() != nil {
+				return submitted
+			}
+			path := fp.Join(dir, entry.Name())
+			if entry.IsDir() || taken[path] {
+				continue
+			}
+			rule := config.ruleFor(path)
+			if rule == nil {
+				continue
+			}
+			if info, err := entry.Info(); err == nil && ledger.Delivered(path, info) {
+				slog.Debug("already delivered", "path", path)
+				continue
+			}
+			processing.Submit(config, rule, []string{path})
+			submitted[path] = true
+		}
 	}
 
-	go postToLocalWebInterface(rmDocPath, rmDocBuff, filepath)
+	if len(submitted) > 0 {
+		slog.Info("screenshots from before the start picked up", "count", len(submitted))
+	}
+	return submitted
+}
 
-	multiData = nil
+// watchSignals are what the watcher reacts to besides the new files
+type watchSignals struct {
+	reload  <-chan *Config   // Configs to switch to
+	trigger <-chan os.Signal // Closes the multiple mode session
+	ready   chan<- struct{}  // Closed once the directories are watched, when not nil
 }
 
-func watchForScreenshots(dirToSearch, filePrefix string) {
+// watchForScreenshots converts the new screenshots until ctx is done and
+// returns the config in use at that point
+func watchForScreenshots(ctx context.Context, config *Config, signals watchSignals) (*Config, error) {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
-		log.Fatal(err)
+		return config, err
 	}
 	defer watcher.Close()
 
-	err = watcher.Add(dirToSearch)
-	if err != nil {
-		log.Fatal(err)
+	if err := watchDirs(watcher, config); err != nil {
+		return config, err
 	}
 
-	//Predicates
-	doesItContainPrefix := func(event fsnotify.Event) bool {
-		return strings.HasPrefix(fp.Base(event.Name), filePrefix)
+	// Files created while the directories were being listed show up twice
+	pickedUp := pickUpScreenshots(ctx, config)
+	if signals.ready != nil {
+		close(signals.ready)
 	}
+
 	isNewFile := func(event fsnotify.Event) bool {
 		return event.Has(fsnotify.Create)
 	}
 
+	// Multiple mode: the trigger closes the session, and so does turning the
+	// mode off, which is polled while a session is open
+	var session multiSession
+	toggle := time.NewTicker(2 * time.Second)
+	defer toggle.Stop()
+
 	for {
 		select {
+		case <-ctx.Done():
+			session.close(config, "shutting down")
+			return config, nil
+
 		case event, ok := <-watcher.Events:
 			if !ok {
-				return
+				return config, nil
+			}
+			if !isNewFile(event) {
+				continue
+			}
+			if pickedUp[event.Name] {
+				delete(pickedUp, event.Name)
+				continue
+			}
+			if watching.paused.Load() {
+				continue
+			}
+
+			if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+				if config.watchesRecursively(event.Name) {
+					if err := watchDirs(watcher, config); err != nil {
+						slog.Error("cannot watch the new directory", "dir", event.Name, "err", err)
+					}
+				}
+				continue
+			}
+
+			rule := config.ruleFor(event.Name)
+			if rule == nil {
+				continue
 			}
 
-			if isNewFile(event) && doesItContainPrefix(event) {
-				//DO NOT REMOVE: The remarkable takes 1200ms to save the png to /home/root
-				//Wasted hours for trying to fix this: 25
-				time.Sleep(1200 * time.Millisecond)
-				rp.DebugPrint("Screenshot found: " + event.Name)
-				singleConversionMode(event.Name)
+			slog.Debug("screenshot found", "path", event.Name, "rule", rule.Name)
+			if isMultipleModeActive(config) {
+				if session.isOpen() && session.rule != rule {
+					session.close(config, "rule changed")
+				}
+				session.add(event.Name, rule, config.SessionTimeout)
+				slog.Info("page added to the multiple mode session", "path", event.Name, "page", len(session.sources))
+			} else {
+				session.close(config, "multiple mode off")
+				processing.Submit(config, rule, []string{event.Name})
+			}
+
+		case <-watching.resume:
+			slog.Info("resumed")
+			for path := range pickUpScreenshots(ctx, config, session.sources...) {
+				pickedUp[path] = true
+			}
+
+		case <-session.expired():
+			session.close(config, "timeout")
+
+		case <-signals.trigger:
+			session.close(config, "triggered")
+
+		case <-toggle.C:
+			if session.isOpen() && !isMultipleModeActive(config) {
+				session.close(config, "multiple mode off")
 			}
 
 		case err, ok := <-watcher.Errors:
 			if !ok {
-				return
+				return config, nil
+			}
+			slog.Error("watcher error", "err", err)
+
+		case newConfig := <-signals.reload:
+			err := watchDirs(watcher, newConfig)
+			if err == nil {
+				err = newConfig.createFolders()
+			}
+			if err != nil {
+				slog.Error("keeping the previous config", "err", err)
+				watchDirs(watcher, config)
+				continue
 			}
-			log.Println("error:", err)
+			// The session belongs to rules of the previous config
+			session.close(config, "config reloaded")
+			config = newConfig
+			applyConfig(config)
+			slog.Info("config reloaded")
 		}
 	}
 }
 
+// applyConfig updates the process wide settings taken from the config
+func applyConfig(config *Config) {
+	currentConfig.Store(config)
+	if err := configureLogging(config); err != nil {
+		slog.Error("cannot set up logging", "err", err)
+	}
+	httpClient.Store(newHTTPClient(config.HTTPTimeout))
+	if uploads != nil {
+		uploads.Configure(config)
+	}
+	if processing != nil {
+		processing.Configure(config)
+	}
+}
+
+// loadAndPrepareConfig loads the config and resolves its folder and name template
+func loadAndPrepareConfig(path string, overrides ConfigOverrides) (*Config, error) {
+	config, err := LoadConfig(path, overrides)
+	if err != nil {
+		return nil, err
+	}
+	if err := config.prepare(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// reloadOnHangup sends a freshly loaded config on every SIGHUP, keeping the
+// running one when the file is invalid
+func reloadOnHangup(path string, overrides ConfigOverrides) <-chan *Config {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	reload := make(chan *Config)
+	go func() {
+		for range hangup {
+			config, err := loadAndPrepareConfig(path, overrides)
+			if err == nil {
+				err = config.validateWatch()
+			}
+			if err != nil {
+				slog.Error("cannot reload the config", "err", err)
+				continue
+			}
+			reload <- config
+		}
+	}()
+	return reload
+}
+
 func deleteFile(filepath string) error {
 	return os.Remove(filepath)
 }
 
-func AppStart() {
+// AppStart runs the screenshot watcher until SIGTERM or SIGINT, then lets the
+// conversions and the upload in progress finish
+func AppStart(args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	once := fs.Bool("once", false, "convert the screenshots already there, try to deliver them once and exit")
+	configPath, overrides := RegisterConfigFlags(fs)
+	fs.Parse(args)
+
+	config, err := loadAndPrepareConfig(*configPath, overrides)
+	if err != nil {
+		return err
+	}
+	if err := config.validateWatch(); err != nil {
+		return err
+	}
+	if err := config.createFolders(); err != nil {
+		return err
+	}
+	applyConfig(config)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
 
-	config := &Config{
-		DirToSearch: "/home/root",
-		FilePrefix:  "Screenshot",
+	ledger, err = OpenLedger(config.Ledger)
+	if err != nil {
+		return err
+	}
+	sequences, err = OpenSequences(fp.Join(fp.Dir(config.Ledger), SEQUENCES_FILE))
+	if err != nil {
+		return err
+	}
+	uploads, err = NewUploadQueue(config.QueueDir, config)
+	if err != nil {
+		return err
+	}
+	if *once {
+		return processBacklog(ctx, config)
+	}
+	stopUploads, uploadsStopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		uploads.Run(stopUploads)
+		close(uploadsStopped)
+	}()
+	processing = NewProcessor(config)
+	if config.APIListen != "" {
+		if err := serveAPI(ctx, config); err != nil {
+			return err
+		}
 	}
 
-	fmt.Println("<--- Looking for new Screenshots --->")
-	watchForScreenshots(config.DirToSearch, config.FilePrefix)
+	// SIGUSR1 closes the multiple mode session
+	trigger := make(chan os.Signal, 1)
+	signal.Notify(trigger, syscall.SIGUSR1)
+	defer signal.Stop(trigger)
+
+	slog.Info("looking for new screenshots")
+	config, err = watchForScreenshots(ctx, config, watchSignals{
+		reload:  reloadOnHangup(*configPath, overrides),
+		trigger: trigger,
+	})
+
+	slog.Info("shutting down")
+	processing.Close(config.ShutdownTimeout)
+	close(stopUploads)
+	<-uploadsStopped
+	xochitl.flush()
+	slog.Info("stopped", "uploads_left", uploads.Pending())
+
+	return err
+}
 
+// processBacklog converts the screenshots already in the watched directories,
+// makes one delivery attempt for every queued document and fails when some
+// are left in the queue
+func processBacklog(ctx context.Context, config *Config) error {
+	processing = NewProcessor(config)
+	pickUpScreenshots(ctx, config)
+	processing.Close(0)
+
+	uploads.AttemptAll()
+	xochitl.flush()
+	if pending := uploads.Pending(); pending > 0 {
+		return fmt.Errorf("%d uploads left in %s for the next run", pending, config.QueueDir)
+	}
+	return nil
 }
 
 func main() {
-	AppStart()
-
+	if err := runCommand(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "drawj2d-go:", err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Stages timed outside of the image pipelines
+const (
+	STAGE_EXPORT = "export" // xochitl layout written to export_dir
+	STAGE_ZIP    = "zip"    // .rmdoc packaging
+	STAGE_UPLOAD = "upload" // Delivery to the sink
+)
+
+// metrics accumulates the timings and counts of the service
+var metrics = newMetrics()
+
+// stageSummary sums up the durations of one stage
+type stageSummary struct {
+	Count int64         `json:"count"`
+	Total time.Duration `json:"total"`
+	Max   time.Duration `json:"max"`
+}
+
+// Metrics collects per-stage durations and counters since the start
+type Metrics struct {
+	mu       sync.Mutex
+	stages   map[string]*stageSummary
+	counters map[string]int64
+}
+
+func newMetrics() *Metrics {
+	return &Metrics{stages: make(map[string]*stageSummary), counters: make(map[string]int64)}
+}
+
+// observe records one run of stage
+func (m *Metrics) observe(stage string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	summary := m.stages[stage]
+	if summary == nil {
+		summary = &stageSummary{}
+		m.stages[stage] = summary
+	}
+	summary.Count++
+	summary.Total += d
+	summary.Max = max(summary.Max, d)
+}
+
+// add increases the counter, named like its Prometheus metric
+func (m *Metrics) add(counter string, n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counters[counter] += n
+}
+
+// WritePrometheus writes the metrics in the Prometheus text format
+func (m *Metrics) WritePrometheus(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP drawj2d_stage_seconds Time spent in each conversion stage.")
+	fmt.Fprintln(w, "# TYPE drawj2d_stage_seconds summary")
+	for _, stage := range sortedKeys(m.stages) {
+		summary := m.stages[stage]
+		fmt.Fprintf(w, "drawj2d_stage_seconds_sum{stage=%q} %g\n", stage, summary.Total.Seconds())
+		fmt.Fprintf(w, "drawj2d_stage_seconds_count{stage=%q} %d\n", stage, summary.Count)
+	}
+	fmt.Fprintln(w, "# HELP drawj2d_stage_max_seconds Longest run of each conversion stage.")
+	fmt.Fprintln(w, "# TYPE drawj2d_stage_max_seconds gauge")
+	for _, stage := range sortedKeys(m.stages) {
+		fmt.Fprintf(w, "drawj2d_stage_max_seconds{stage=%q} %g\n", stage, m.stages[stage].Max.Seconds())
+	}
+	for _, counter := range sortedKeys(m.counters) {
+		fmt.Fprintf(w, "# TYPE %s counter\n%s %d\n", counter, counter, m.counters[counter])
+	}
+	if uploads != nil {
+		fmt.Fprintf(w, "# TYPE drawj2d_uploads_pending gauge\ndrawj2d_uploads_pending %d\n", uploads.Pending())
+	}
+}
+
+// Snapshot returns the stage summaries and counters
+func (m *Metrics) Snapshot() map[string]any {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stages := make(map[string]stageSummary, len(m.stages))
+	for stage, summary := range m.stages {
+		stages[stage] = *summary
+	}
+	counters := make(map[string]int64, len(m.counters))
+	for counter, n := range m.counters {
+		counters[counter] = n
+	}
+	return map[string]any{"stages": stages, "counters": counters}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	rp "github.com/pragmatically-dev/PoC-drawj2d-port-go/remarkablepage"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	m := newMetrics()
+	m.observe(rp.STAGE_BLUR, 20*time.Millisecond)
+	m.observe(rp.STAGE_BLUR, 40*time.Millisecond)
+	m.add("drawj2d_pages_total", 3)
+
+	var out strings.Builder
+	m.WritePrometheus(&out)
+	for _, line := range []string{
+		`drawj2d_stage_seconds_sum{stage="blur"} 0.06`,
+		`drawj2d_stage_seconds_count{stage="blur"} 2`,
+		`drawj2d_stage_max_seconds{stage="blur"} 0.04`,
+		`drawj2d_pages_total 3`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("no %q in\n%s", line, out.String())
+		}
+	}
+}
+
+func TestJobRecordsStagesAndCounts(t *testing.T) {
+	convert := func(path string, mode rp.Mode, layout rp.Layout) ([][]byte, rp.ConversionStats, error) {
+		pages, _, _ := emptyPage(path, mode, layout)
+		return pages, rp.ConversionStats{
+			Stages: []rp.StageTiming{{Stage: rp.STAGE_BLUR, Duration: 5 * time.Millisecond}},
+			Lines:  4,
+			Points: 8,
+			Size:   len(pages[0]),
+		}, nil
+	}
+	config, rule := startProcessing(t, testQueueConfig(), convert, func(string, []byte, string) error { return nil })
+
+	first := writeScreenshot(t, config.DirToSearch, "Screenshot_1.png")
+	second := writeScreenshot(t, config.DirToSearch, "Screenshot_2.png")
+	job := waitForJob(t, processing.Submit(config, rule, []string{first, second}))
+
+	if job.State != JOB_DONE {
+		t.Fatalf("job = %+v", job)
+	}
+	if job.Lines != 8 || job.Points != 16 || job.RmBytes == 0 || job.RmdocBytes == 0 {
+		t.Errorf("counts = %d lines, %d points, %d rm bytes, %d rmdoc bytes", job.Lines, job.Points, job.RmBytes, job.RmdocBytes)
+	}
+	if job.TimingsMS[rp.STAGE_BLUR] != 10 {
+		t.Errorf("blur took %vms, want 10", job.TimingsMS[rp.STAGE_BLUR])
+	}
+	for _, stage := range []string{STAGE_ZIP, STAGE_UPLOAD} {
+		if _, ok := job.TimingsMS[stage]; !ok {
+			t.Errorf("no %s timing in %v", stage, job.TimingsMS)
+		}
+	}
+}
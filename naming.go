@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	fp "path/filepath"
+
+	rp "github.com/pragmatically-dev/PoC-drawj2d-port-go/remarkablepage"
+)
+
+// DEFAULT_NAME_TEMPLATE keeps the screenshot file name as the document name
+const DEFAULT_NAME_TEMPLATE = "{name}"
+
+var namePlaceholder = regexp.MustCompile(`\{(\w+)(?::([^}]*))?\}`)
+
+// Namer builds document names from a template. Placeholders:
+//
+//	{name}          screenshot file name without extension
+//	{dir}           name of the directory the screenshot is in
+//	{date[:layout]} screenshot time, Go layout, 2006-01-02 by default
+//	{time[:layout]} screenshot time, Go layout, 15-04-05 by default
+//	{seq[:width]}   counter of documents named with the template, zero padded
+//	                to width, kept in sequences when the watcher runs
+type Namer struct {
+	template string
+	mu       sync.Mutex
+	seq      int
+}
+
+// SEQUENCES_FILE keeps the {seq} counters, next to the ledger
+const SEQUENCES_FILE = "sequences.json"
+
+// sequences persists the {seq} counters of the watcher, nil for the one-shot
+// commands, which count from 1
+var sequences *Sequences
+
+// Sequences keeps the last {seq} value of every name template on disk, so
+// numbering carries on across restarts and config reloads
+type Sequences struct {
+	path     string
+	mu       sync.Mutex
+	counters map[string]int
+}
+
+// OpenSequences loads the counters saved at path, none when it does not exist
+func OpenSequences(path string) (*Sequences, error) {
+	s := &Sequences{path: path, counters: make(map[string]int)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.counters); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Next increments the counter of the template and saves it
+func (s *Sequences) Next(template string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.counters[template]++
+	data, err := json.Marshal(s.counters)
+	if err != nil {
+		return s.counters[template], err
+	}
+	if err := os.MkdirAll(fp.Dir(s.path), 0755); err != nil {
+		return s.counters[template], err
+	}
+	return s.counters[template], writeFileSynced(s.path, data)
+}
+
+// NewNamer validates the template and returns a Namer counting from 1
+func NewNamer(template string) (*Namer, error) {
+	if template == "" {
+		template = DEFAULT_NAME_TEMPLATE
+	}
+	for _, match := range namePlaceholder.FindAllStringSubmatch(template, -1) {
+		switch match[1] {
+		case "name", "dir", "date", "time":
+		case "seq":
+			if _, err := seqWidth(match[2]); err != nil {
+				return nil, fmt.Errorf("name template %q: %w", template, err)
+			}
+		default:
+			return nil, fmt.Errorf("name template %q: unknown placeholder {%s}", template, match[1])
+		}
+	}
+	return &Namer{template: template}, nil
+}
+
+// Name returns the document name of the screenshot at filepath
+func (namer *Namer) Name(filepath string) string {
+	seq := namer.next()
+
+	taken := time.Now()
+	if info, err := os.Stat(filepath); err == nil {
+		taken = info.ModTime()
+	}
+
+	name := namePlaceholder.ReplaceAllStringFunc(namer.template, func(placeholder string) string {
+		match := namePlaceholder.FindStringSubmatch(placeholder)
+		key, arg := match[1], match[2]
+		switch key {
+		case "name":
+			return rp.GetFileNameWithoutExtension(filepath)
+		case "dir":
+			return fp.Base(fp.Dir(filepath))
+		case "date":
+			return taken.Format(orDefault(arg, "2006-01-02"))
+		case "time":
+			return taken.Format(orDefault(arg, "15-04-05"))
+		case "seq":
+			width, _ := seqWidth(arg)
+			return fmt.Sprintf("%0*d", width, seq)
+		}
+		return placeholder
+	})
+
+	// Names become file names on the way to the tablet
+	name = strings.TrimSpace(strings.ReplaceAll(name, "/", "-"))
+	if name == "" {
+		return rp.GetFileNameWithoutExtension(filepath)
+	}
+	return name
+}
+
+// next returns the next {seq} value, counted in sequences when the template
+// uses it
+func (namer *Namer) next() int {
+	namer.mu.Lock()
+	defer namer.mu.Unlock()
+
+	namer.seq++
+	if sequences != nil && strings.Contains(namer.template, "{seq") {
+		seq, err := sequences.Next(namer.template)
+		if err != nil {
+			slog.Warn("cannot save the document counter", "err", err)
+		}
+		namer.seq = seq
+	}
+	return namer.seq
+}
+
+func seqWidth(arg string) (int, error) {
+	if arg == "" {
+		return 0, nil
+	}
+	width, err := strconv.Atoi(arg)
+	if err != nil || width < 0 {
+		return 0, fmt.Errorf("invalid {seq} width %q", arg)
+	}
+	return width, nil
+}
+
+func orDefault(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	fp "path/filepath"
+)
+
+func TestNamerTemplate(t *testing.T) {
+	dir := t.TempDir()
+	shot := fp.Join(dir, "Screenshot_1.png")
+	os.WriteFile(shot, nil, 0644)
+	taken := time.Date(2026, 3, 14, 9, 26, 53, 0, time.Local)
+	os.Chtimes(shot, taken, taken)
+
+	namer, err := NewNamer("{date} {time:15h04} #{seq:3} {dir}/{name}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "2026-03-14 09h26 #001 " + fp.Base(dir) + "-Screenshot_1"
+	if name := namer.Name(shot); name != want {
+		t.Fatalf("unexpected name %q, want %q", name, want)
+	}
+	if name := namer.Name(shot); name[:21] != "2026-03-14 09h26 #002" {
+		t.Fatalf("unexpected name %q", name)
+	}
+
+	if _, err := NewNamer("{title}"); err == nil {
+		t.Fatal("expected an error for an unknown placeholder")
+	}
+}
+
+func TestSequencesSurviveRestart(t *testing.T) {
+	path := fp.Join(t.TempDir(), SEQUENCES_FILE)
+	t.Cleanup(func() { sequences = nil })
+
+	var names []string
+	for run := 0; run < 2; run++ {
+		// Every run reopens the counters, as the service does at start
+		var err error
+		if sequences, err = OpenSequences(path); err != nil {
+			t.Fatal(err)
+		}
+		namer, _ := NewNamer("Scan {seq}")
+		other, _ := NewNamer("Scan {seq}") // A reloaded config
+		names = append(names, namer.Name("a.png"), other.Name("b.png"))
+	}
+	if want := "Scan 1,Scan 2,Scan 3,Scan 4"; strings.Join(names, ",") != want {
+		t.Errorf("names %v, want %s", names, want)
+	}
+}
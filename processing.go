@@ -0,0 +1,519 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	fp "path/filepath"
+
+	"github.com/google/uuid"
+	rp "github.com/pragmatically-dev/PoC-drawj2d-port-go/remarkablepage"
+)
+
+// States of a conversion job, in the order they are reached
+type JobState string
+
+const (
+	JOB_QUEUED     JobState = "queued"
+	JOB_CONVERTING JobState = "converting" // Waiting for the screenshots to be written and tracing them
+	JOB_PACKAGING  JobState = "packaging"  // Building the .rmdoc
+	JOB_DELIVERING JobState = "delivering" // In the upload queue
+	JOB_CLEANUP    JobState = "cleanup"    // Delivered, removing the screenshots
+	JOB_DONE       JobState = "done"
+	JOB_FAILED     JobState = "failed"
+)
+
+// JOB_HISTORY is the number of finished jobs kept for inspection
+const JOB_HISTORY = 100
+
+// MEMORY_POLL is how often a conversion held back for memory checks again
+const MEMORY_POLL = 250 * time.Millisecond
+
+// processing converts the screenshots, set up by AppStart
+var processing *Processor
+
+// conversionJob turns one or more screenshots into a document
+type conversionJob struct {
+	ID      string
+	Rule    *WatchRule
+	Sources []string
+	State   JobState
+	Error   string
+	Created time.Time
+	Updated time.Time
+
+	// Time spent per stage, summed over the pages, and size of the output
+	Timings    map[string]time.Duration
+	Lines      int
+	Points     int
+	RmBytes    int
+	RmdocBytes int
+
+	config *Config
+}
+
+// logger returns the default logger tagged with the job ID, which the upload
+// of its document carries on
+func (job *conversionJob) logger() *slog.Logger {
+	return slog.With("job", shortID(job.ID))
+}
+
+// JobStatus is a snapshot of a conversion job
+type JobStatus struct {
+	ID      string    `json:"id"`
+	Rule    string    `json:"rule"`
+	Sources []string  `json:"sources"`
+	State   JobState  `json:"state"`
+	Error   string    `json:"error,omitempty"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+
+	TimingsMS  map[string]float64 `json:"timings_ms,omitempty"`
+	Lines      int                `json:"lines"`
+	Points     int                `json:"points"`
+	RmBytes    int                `json:"rm_bytes"`
+	RmdocBytes int                `json:"rmdoc_bytes"`
+}
+
+// Processor runs the conversions on a bounded number of workers. Submitting
+// blocks while the job queue is full, and a conversion only starts while
+// enough memory is available, unless it would be the only one running.
+type Processor struct {
+	tasks   chan *conversionJob
+	abandon chan struct{} // Closed when the queued jobs are no longer converted
+
+	// convert traces one image onto its pages, rp.ConvertImageLayout outside of tests
+	convert func(imagePath string, mode rp.Mode, layout rp.Layout) ([][]byte, rp.ConversionStats, error)
+
+	// memAvailable returns the available memory in bytes, or false when unknown
+	memAvailable func() (uint64, bool)
+
+	mu       sync.Mutex
+	minFree  uint64 // Bytes of available memory needed to start a concurrent conversion
+	jobs     map[string]*conversionJob
+	finished []string // IDs of the finished jobs, oldest first
+	running  int      // Conversions in progress
+	workers  sync.WaitGroup
+	closing  sync.Once
+}
+
+// NewProcessor starts config.Workers workers taking jobs from a queue of
+// config.JobQueueSize
+func NewProcessor(config *Config) *Processor {
+	processor := &Processor{
+		tasks:        make(chan *conversionJob, config.JobQueueSize),
+		abandon:      make(chan struct{}),
+		convert:      rp.ConvertImageLayout,
+		memAvailable: memAvailable,
+		jobs:         make(map[string]*conversionJob),
+	}
+	processor.Configure(config)
+	for i := 0; i < config.Workers; i++ {
+		processor.workers.Add(1)
+		go func() {
+			defer processor.workers.Done()
+			for job := range processor.tasks {
+				select {
+				case <-processor.abandon:
+					// The screenshots stay in place for the next start
+					processor.finish(job, JOB_FAILED, errors.New("shut down before the conversion"))
+				default:
+					processor.run(job)
+				}
+			}
+		}()
+	}
+	return processor
+}
+
+// Configure applies the memory setting of a (re)loaded config; the number of
+// workers and the queue size only change on restart
+func (processor *Processor) Configure(config *Config) {
+	processor.mu.Lock()
+	defer processor.mu.Unlock()
+	processor.minFree = uint64(config.MinFreeMemoryMB) << 20
+}
+
+// Submit queues the conversion of sources into one document following rule,
+// waiting while the queue is full
+func (processor *Processor) Submit(config *Config, rule *WatchRule, sources []string) string {
+	job := &conversionJob{
+		ID:      uuid.NewString(),
+		Rule:    rule,
+		Sources: sources,
+		State:   JOB_QUEUED,
+		Created: time.Now(),
+		Updated: time.Now(),
+		Timings: make(map[string]time.Duration),
+		config:  config,
+	}
+
+	processor.mu.Lock()
+	processor.jobs[job.ID] = job
+	processor.mu.Unlock()
+
+	job.logger().Debug("conversion queued", "rule", rule.Name, "sources", sources)
+	if len(processor.tasks) == cap(processor.tasks) {
+		slog.Warn("conversion queue full, waiting", "size", cap(processor.tasks))
+	}
+	processor.tasks <- job
+	return job.ID
+}
+
+// Jobs returns the tracked jobs, oldest first
+func (processor *Processor) Jobs() []JobStatus {
+	processor.mu.Lock()
+	defer processor.mu.Unlock()
+
+	statuses := make([]JobStatus, 0, len(processor.jobs))
+	for _, job := range processor.jobs {
+		status := JobStatus{
+			ID:         job.ID,
+			Rule:       job.Rule.Name,
+			Sources:    job.Sources,
+			State:      job.State,
+			Error:      job.Error,
+			Created:    job.Created,
+			Updated:    job.Updated,
+			TimingsMS:  make(map[string]float64, len(job.Timings)),
+			Lines:      job.Lines,
+			Points:     job.Points,
+			RmBytes:    job.RmBytes,
+			RmdocBytes: job.RmdocBytes,
+		}
+		for stage, d := range job.Timings {
+			status.TimingsMS[stage] = float64(d) / float64(time.Millisecond)
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Created.Before(statuses[j].Created) })
+	return statuses
+}
+
+// ConvertNow converts one image into its pages outside of the job queue,
+// laid out as the config says and still waiting for memory like the queued
+// conversions
+func (processor *Processor) ConvertNow(config *Config, imagePath string, mode rp.Mode, landscape bool) ([][]byte, error) {
+	processor.admit()
+	defer processor.release()
+	pages, stats, err := processor.pages(config, imagePath, mode, landscape)
+	for _, stage := range stats.Stages {
+		metrics.observe(stage.Stage, stage.Duration)
+	}
+	return pages, err
+}
+
+// Sources returns the screenshots of the jobs not handed to the upload queue yet
+func (processor *Processor) Sources() map[string]bool {
+	processor.mu.Lock()
+	defer processor.mu.Unlock()
+
+	sources := make(map[string]bool)
+	for _, job := range processor.jobs {
+		switch job.State {
+		case JOB_QUEUED, JOB_CONVERTING, JOB_PACKAGING:
+			for _, source := range job.Sources {
+				sources[source] = true
+			}
+		}
+	}
+	return sources
+}
+
+// Close stops taking jobs and waits for the workers to finish the queued
+// ones. Past timeout the conversions in progress are still completed, but the
+// jobs not started yet are dropped, their screenshots left for the next start.
+// A zero timeout waits for every job.
+func (processor *Processor) Close(timeout time.Duration) {
+	processor.closing.Do(func() { processor.close(timeout) })
+}
+
+func (processor *Processor) close(timeout time.Duration) {
+	close(processor.tasks)
+
+	drained := make(chan struct{})
+	go func() {
+		processor.workers.Wait()
+		close(drained)
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		expired = time.After(timeout)
+	}
+	select {
+	case <-drained:
+	case <-expired:
+		slog.Warn("shutdown timeout, leaving the queued screenshots for the next start", "timeout", timeout)
+		close(processor.abandon)
+		<-drained
+	}
+}
+
+// run converts and packages the job within the job timeout, then hands the
+// document to the upload queue
+func (processor *Processor) run(job *conversionJob) {
+	defer runtime.GC()
+	config := job.config
+
+	type result struct {
+		doc document
+		err error
+	}
+	done := make(chan result, 1)
+
+	processor.admit()
+	go func() {
+		defer processor.release()
+		var r result
+		r.doc, r.err = processor.build(job)
+		done <- r
+	}()
+
+	timeout := time.NewTimer(config.JobTimeout)
+	defer timeout.Stop()
+
+	var r result
+	select {
+	case r = <-done:
+	case <-timeout.C:
+		processor.finish(job, JOB_FAILED, fmt.Errorf("timed out after %s", config.JobTimeout))
+		// The conversion cannot be interrupted: keep the worker busy until it
+		// returns so the concurrency stays bounded, and drop its result
+		<-done
+		return
+	}
+	if r.err != nil {
+		processor.finish(job, JOB_FAILED, r.err)
+		return
+	}
+
+	processor.setState(job, JOB_DELIVERING)
+	// Only the converted screenshots are recorded and retained once delivered,
+	// the others are left in place
+	doc := r.doc
+	if err := uploads.EnqueueFor(job.ID, job.Rule.sink, doc.name, doc.rmdoc, doc.opts.Parent, doc.sources); err != nil {
+		processor.finish(job, JOB_FAILED, fmt.Errorf("queueing %s for upload: %w", doc.name, err))
+	}
+}
+
+// document is a packaged job, with the sources that made it into its pages
+type document struct {
+	name    string
+	rmdoc   []byte
+	opts    rp.DocOptions
+	sources []string
+}
+
+// build converts every source into a page and packages the document
+func (processor *Processor) build(job *conversionJob) (document, error) {
+	config, rule := job.config, job.Rule
+
+	processor.setState(job, JOB_CONVERTING)
+	var doc document
+	var pages [][]byte
+	var landscape bool
+	for _, source := range job.Sources {
+		// The remarkable creates the png before writing it, which takes
+		// about 1200ms: wait for its IEND chunk rather than a fixed delay
+		if err := waitForCompleteFile(source, config.WriteTimeout); err != nil {
+			job.logger().Warn("skipping screenshot", "err", err)
+			continue
+		}
+		// The first screenshot that converts sets the orientation of the document
+		if len(doc.sources) == 0 {
+			landscape = config.landscape(source)
+		}
+		converted, stats, err := processor.pages(config, source, rule.mode, landscape)
+		if err != nil {
+			job.logger().Error("conversion failed", "source", source, "err", err)
+			continue
+		}
+		for _, stage := range stats.Stages {
+			processor.timed(job.ID, stage.Stage, stage.Duration)
+		}
+		processor.mu.Lock()
+		job.Lines += stats.Lines
+		job.Points += stats.Points
+		job.RmBytes += stats.Size
+		processor.mu.Unlock()
+		metrics.add("drawj2d_pages_total", int64(len(converted)))
+		metrics.add("drawj2d_lines_total", int64(stats.Lines))
+		metrics.add("drawj2d_points_total", int64(stats.Points))
+		pages = append(pages, converted...)
+		doc.sources = append(doc.sources, source)
+	}
+	if len(pages) == 0 {
+		return doc, errors.New("no screenshot could be converted")
+	}
+
+	opts, err := config.optionsFor(rule.docOpts, doc.sources[0])
+	if err != nil {
+		return doc, fmt.Errorf("reading the screenshot: %w", err)
+	}
+	opts.Landscape = landscape
+
+	processor.setState(job, JOB_PACKAGING)
+	name := rule.namer.Name(doc.sources[0])
+	if config.ExportDir != "" {
+		start := time.Now()
+		if _, err := rp.ExportXochitlDir(config.ExportDir, name, pages, opts); err != nil {
+			job.logger().Error("export failed", "dir", config.ExportDir, "err", err)
+		}
+		processor.timed(job.ID, STAGE_EXPORT, time.Since(start))
+	}
+
+	start := time.Now()
+	rmDocBuff, rmDocPath, err := rp.CreateRmDocWithOptions(name, pages, opts)
+	if err != nil {
+		return doc, err
+	}
+	processor.timed(job.ID, STAGE_ZIP, time.Since(start))
+	processor.mu.Lock()
+	job.RmdocBytes = rmDocBuff.Len()
+	processor.mu.Unlock()
+	metrics.add("drawj2d_rmdoc_bytes_total", int64(rmDocBuff.Len()))
+	doc.name, doc.rmdoc, doc.opts = fp.Base(rmDocPath), rmDocBuff.Bytes(), opts
+	return doc, nil
+}
+
+// admit waits until a conversion may start: while others are running, the
+// available memory must stay above the configured minimum
+func (processor *Processor) admit() {
+	for {
+		processor.mu.Lock()
+		if processor.running == 0 {
+			processor.running++
+			processor.mu.Unlock()
+			return
+		}
+		if available, known := processor.memAvailable(); !known || available >= processor.minFree {
+			processor.running++
+			processor.mu.Unlock()
+			return
+		}
+		processor.mu.Unlock()
+		time.Sleep(MEMORY_POLL)
+	}
+}
+
+func (processor *Processor) release() {
+	processor.mu.Lock()
+	processor.running--
+	processor.mu.Unlock()
+}
+
+func (processor *Processor) setState(job *conversionJob, state JobState) {
+	processor.mu.Lock()
+	defer processor.mu.Unlock()
+	if job.State == JOB_DONE || job.State == JOB_FAILED {
+		return
+	}
+	job.State = state
+	job.Updated = time.Now()
+}
+
+// finish records the final state of the job and forgets the oldest finished jobs
+func (processor *Processor) finish(job *conversionJob, state JobState, err error) {
+	processor.mu.Lock()
+	defer processor.mu.Unlock()
+
+	if job.State == JOB_DONE || job.State == JOB_FAILED {
+		return
+	}
+	job.State = state
+	job.Updated = time.Now()
+	if err != nil {
+		job.Error = err.Error()
+		job.logger().Error("job failed", "sources", job.Sources, "err", err)
+		recentErrors.add(strings.Join(job.Sources, ", "), err)
+	}
+	metrics.add(fmt.Sprintf("drawj2d_jobs_%s_total", state), 1)
+	if state == JOB_DONE {
+		job.logger().Info("job done", job.summary()...)
+	}
+
+	processor.finished = append(processor.finished, job.ID)
+	for len(processor.finished) > JOB_HISTORY {
+		delete(processor.jobs, processor.finished[0])
+		processor.finished = processor.finished[1:]
+	}
+}
+
+// delivered advances the job once the upload queue is done with its document
+func (processor *Processor) delivered(id string, state JobState, err error) {
+	processor.mu.Lock()
+	job := processor.jobs[id]
+	processor.mu.Unlock()
+
+	if job == nil {
+		// Uploads restored from a previous run have no job
+		return
+	}
+	if state == JOB_CLEANUP {
+		processor.setState(job, state)
+		return
+	}
+	processor.finish(job, state, err)
+}
+
+// pages converts one screenshot into its page, or its tiles when the config
+// tiles the images
+func (processor *Processor) pages(config *Config, source string, mode rp.Mode, landscape bool) ([][]byte, rp.ConversionStats, error) {
+	return processor.convert(source, mode, config.layout(landscape))
+}
+
+// timed adds the duration of a stage to the job with this ID, if still
+// tracked, and to the metrics
+func (processor *Processor) timed(id string, stage string, d time.Duration) {
+	metrics.observe(stage, d)
+
+	processor.mu.Lock()
+	defer processor.mu.Unlock()
+	if job := processor.jobs[id]; job != nil {
+		job.Timings[stage] += d
+	}
+}
+
+// summary returns the timings and sizes of the job as log attributes
+func (job *conversionJob) summary() []any {
+	attrs := []any{"pages", len(job.Sources), "lines", job.Lines, "points", job.Points, "rm_bytes", job.RmBytes, "rmdoc_bytes", job.RmdocBytes}
+	for _, stage := range []string{rp.STAGE_LOAD, rp.STAGE_BLUR, rp.STAGE_LAPLACE, rp.STAGE_MATRIX, rp.STAGE_RUNS, rp.STAGE_DRAW, STAGE_EXPORT, STAGE_ZIP, STAGE_UPLOAD} {
+		if d, ok := job.Timings[stage]; ok {
+			attrs = append(attrs, stage, d.Round(time.Millisecond/10))
+		}
+	}
+	return attrs
+}
+
+// memAvailable reads MemAvailable from /proc/meminfo
+func memAvailable() (uint64, bool) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemAvailable:" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				slog.Warn("cannot parse /proc/meminfo", "err", err)
+				return 0, false
+			}
+			return kb << 10, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	fp "path/filepath"
+
+	rp "github.com/pragmatically-dev/PoC-drawj2d-port-go/remarkablepage"
+)
+
+// startProcessing sets up the global upload queue and processor on a
+// temporary directory, converting with convert and delivering to sink
+func startProcessing(t *testing.T, config *Config, convert func(string, rp.Mode, rp.Layout) ([][]byte, rp.ConversionStats, error), sink funcSink) (*Config, *WatchRule) {
+	dir := t.TempDir()
+	config.DirToSearch = dir
+	config.Sink = SinkConfig{Type: SINK_DIR, Path: dir}
+	if err := config.prepare(); err != nil {
+		t.Fatal(err)
+	}
+
+	queue, err := NewUploadQueue(fp.Join(dir, "queue"), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useSink(queue, sink)
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		queue.Run(stop)
+		close(stopped)
+	}()
+
+	processor := NewProcessor(config)
+	processor.convert = convert
+	uploads, processing = queue, processor
+	t.Cleanup(func() {
+		processor.Close(time.Second)
+		close(stop)
+		<-stopped
+		uploads, processing = nil, nil
+	})
+	return config, config.rules[0]
+}
+
+// writeScreenshot writes a complete, if empty, PNG
+func writeScreenshot(t *testing.T, dir, name string) string {
+	path := fp.Join(dir, name)
+	if err := os.WriteFile(path, append(append([]byte{}, pngSignature...), pngIEND...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func emptyPage(string, rp.Mode, rp.Layout) ([][]byte, rp.ConversionStats, error) {
+	return [][]byte{rp.DrawLines(rp.LineList{}, float32(rp.X_MAX), float32(rp.Y_MAX))}, rp.ConversionStats{}, nil
+}
+
+// waitForJob waits until the job reaches a final state
+func waitForJob(t *testing.T, id string) JobStatus {
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, job := range processing.Jobs() {
+			if job.ID == id && (job.State == JOB_DONE || job.State == JOB_FAILED) {
+				return job
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("job %s not finished: %+v", id, processing.Jobs())
+	return JobStatus{}
+}
+
+func TestProcessorDeliversAndCleansUp(t *testing.T) {
+	var mu sync.Mutex
+	var delivered []string
+	config, rule := startProcessing(t, testQueueConfig(), emptyPage, func(name string, rmdoc []byte, parent string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		delivered = append(delivered, name)
+		return nil
+	})
+
+	first := writeScreenshot(t, config.DirToSearch, "Screenshot_1.png")
+	second := writeScreenshot(t, config.DirToSearch, "Screenshot_2.png")
+	job := waitForJob(t, processing.Submit(config, rule, []string{first, second}))
+
+	if job.State != JOB_DONE || job.Error != "" {
+		t.Fatalf("job = %+v", job)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(delivered) != 1 || delivered[0] != "Screenshot_1.rmdoc" {
+		t.Errorf("delivered %v", delivered)
+	}
+	for _, source := range []string{first, second} {
+		if _, err := os.Stat(source); !os.IsNotExist(err) {
+			t.Errorf("%s not cleaned up", source)
+		}
+	}
+}
+
+func TestProcessorKeepsTheScreenshotsThatFailed(t *testing.T) {
+	failing := func(path string, mode rp.Mode, layout rp.Layout) ([][]byte, rp.ConversionStats, error) {
+		if strings.Contains(path, "Broken") {
+			return nil, rp.ConversionStats{}, errors.New("unreadable")
+		}
+		return emptyPage(path, mode, layout)
+	}
+	var delivered []string
+	config, rule := startProcessing(t, testQueueConfig(), failing, func(name string, rmdoc []byte, parent string) error {
+		delivered = append(delivered, name)
+		return nil
+	})
+	var err error
+	if ledger, err = OpenLedger(fp.Join(config.DirToSearch, "ledger")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ledger = nil })
+
+	broken := writeScreenshot(t, config.DirToSearch, "Broken_1.png")
+	os.WriteFile(broken, append(append([]byte{}, pngSignature...), append([]byte("broken"), pngIEND...)...), 0644)
+	good := writeScreenshot(t, config.DirToSearch, "Screenshot_2.png")
+	job := waitForJob(t, processing.Submit(config, rule, []string{broken, good}))
+
+	if job.State != JOB_DONE {
+		t.Fatalf("job = %+v", job)
+	}
+	if len(delivered) != 1 || delivered[0] != "Screenshot_2.rmdoc" {
+		t.Errorf("delivered %v, want the document named after the converted screenshot", delivered)
+	}
+	if _, err := os.Stat(good); !os.IsNotExist(err) {
+		t.Errorf("converted screenshot not cleaned up")
+	}
+	if _, err := os.Stat(broken); err != nil {
+		t.Fatalf("screenshot that failed to convert removed: %v", err)
+	}
+	if hash, _ := hashFile(broken); ledger.Contains(hash) {
+		t.Error("screenshot that failed to convert recorded as delivered")
+	}
+}
+
+func TestProcessorTimeoutKeepsTheScreenshot(t *testing.T) {
+	config := testQueueConfig()
+	config.JobTimeout = 20 * time.Millisecond
+	slow := func(path string, mode rp.Mode, layout rp.Layout) ([][]byte, rp.ConversionStats, error) {
+		time.Sleep(100 * time.Millisecond)
+		return emptyPage(path, mode, layout)
+	}
+	config, rule := startProcessing(t, config, slow, func(string, []byte, string) error {
+		t.Error("a timed out job was delivered")
+		return nil
+	})
+
+	source := writeScreenshot(t, config.DirToSearch, "Screenshot.png")
+	job := waitForJob(t, processing.Submit(config, rule, []string{source}))
+
+	if job.State != JOB_FAILED || !strings.Contains(job.Error, "timed out") {
+		t.Fatalf("job = %+v", job)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if _, err := os.Stat(source); err != nil {
+		t.Errorf("screenshot of a failed job removed: %v", err)
+	}
+}
+
+func TestProcessorHoldsBackConversionsWithoutMemory(t *testing.T) {
+	var mu sync.Mutex
+	running, most := 0, 0
+	convert := func(path string, mode rp.Mode, layout rp.Layout) ([][]byte, rp.ConversionStats, error) {
+		mu.Lock()
+		running++
+		most = max(most, running)
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return emptyPage(path, mode, layout)
+	}
+
+	config := testQueueConfig()
+	config.Workers = 3
+	config, rule := startProcessing(t, config, convert, func(string, []byte, string) error { return nil })
+	processing.memAvailable = func() (uint64, bool) { return 1 << 20, true }
+
+	var ids []string
+	for _, name := range []string{"a.png", "b.png", "c.png"} {
+		ids = append(ids, processing.Submit(config, rule, []string{writeScreenshot(t, config.DirToSearch, name)}))
+	}
+	for _, id := range ids {
+		if job := waitForJob(t, id); job.State != JOB_DONE {
+			t.Errorf("job = %+v", job)
+		}
+	}
+	if most != 1 {
+		t.Errorf("%d conversions ran at once with 1 MB available", most)
+	}
+}
+
+func TestCloseLeavesQueuedScreenshotsAfterTimeout(t *testing.T) {
+	config := testQueueConfig()
+	config.Workers = 1
+	slow := func(path string, mode rp.Mode, layout rp.Layout) ([][]byte, rp.ConversionStats, error) {
+		time.Sleep(50 * time.Millisecond)
+		return emptyPage(path, mode, layout)
+	}
+	config, rule := startProcessing(t, config, slow, func(string, []byte, string) error { return nil })
+
+	first := processing.Submit(config, rule, []string{writeScreenshot(t, config.DirToSearch, "a.png")})
+	queued := writeScreenshot(t, config.DirToSearch, "b.png")
+	second := processing.Submit(config, rule, []string{queued})
+	time.Sleep(10 * time.Millisecond)
+
+	processing.Close(time.Millisecond)
+
+	states := make(map[string]JobState)
+	for _, job := range processing.Jobs() {
+		states[job.ID] = job.State
+	}
+	if states[first] == JOB_FAILED || states[second] != JOB_FAILED {
+		t.Errorf("states after shutdown = %v", states)
+	}
+	if _, err := os.Stat(queued); err != nil {
+		t.Errorf("screenshot of the dropped job removed: %v", err)
+	}
+}
+
+func TestPickUpScreenshotsSkipsQueuedOnes(t *testing.T) {
+	config, _ := startProcessing(t, testQueueConfig(), emptyPage, func(string, []byte, string) error {
+		return errors.New("web interface down")
+	})
+
+	queued := writeScreenshot(t, config.DirToSearch, "Screenshot_1.png")
+	if err := uploads.Enqueue(config.Sink, "Screenshot_1.rmdoc", []byte("rmdoc"), "", []string{queued}); err != nil {
+		t.Fatal(err)
+	}
+	missed := writeScreenshot(t, config.DirToSearch, "Screenshot_2.png")
+	writeScreenshot(t, config.DirToSearch, "Notes.png")
+
+	pickedUp := pickUpScreenshots(context.Background(), config)
+	if len(pickedUp) != 1 || !pickedUp[missed] {
+		t.Errorf("picked up %v, want only %s", pickedUp, missed)
+	}
+}
+
+func TestProcessorLandscapeDocuments(t *testing.T) {
+	delivered := make(chan []byte, 1)
+	config := testQueueConfig()
+	config.Orientation = ORIENTATION_LANDSCAPE
+	config, rule := startProcessing(t, config, emptyPage, func(name string, rmdoc []byte, parent string) error {
+		delivered <- rmdoc
+		return nil
+	})
+
+	source := fp.Join(config.DirToSearch, "Screenshot_1.png")
+	os.WriteFile(source, pngImage(t), 0644)
+	if job := waitForJob(t, processing.Submit(config, rule, []string{source})); job.State != JOB_DONE {
+		t.Fatalf("job = %+v", job)
+	}
+
+	info, err := rp.ReadRmDoc(<-delivered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Orientation != ORIENTATION_LANDSCAPE {
+		t.Errorf("orientation %q", info.Orientation)
+	}
+}
+
+func TestProcessorOrientationFromFirstConvertedScreenshot(t *testing.T) {
+	failing := func(path string, mode rp.Mode, layout rp.Layout) ([][]byte, rp.ConversionStats, error) {
+		if strings.Contains(path, "Broken") {
+			return nil, rp.ConversionStats{}, errors.New("unreadable")
+		}
+		return emptyPage(path, mode, layout)
+	}
+	delivered := make(chan []byte, 1)
+	config := testQueueConfig()
+	config.Orientation = ORIENTATION_AUTO
+	config, rule := startProcessing(t, config, failing, func(name string, rmdoc []byte, parent string) error {
+		delivered <- rmdoc
+		return nil
+	})
+
+	sized := func(name string, width, height int) string {
+		var buf bytes.Buffer
+		png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height)))
+		path := fp.Join(config.DirToSearch, name)
+		os.WriteFile(path, buf.Bytes(), 0644)
+		return path
+	}
+	tall := sized("Broken.png", 20, 40)
+	wide := sized("Screenshot.png", 40, 20)
+	if job := waitForJob(t, processing.Submit(config, rule, []string{tall, wide})); job.State != JOB_DONE {
+		t.Fatalf("job = %+v", job)
+	}
+
+	info, err := rp.ReadRmDoc(<-delivered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Orientation != ORIENTATION_LANDSCAPE {
+		t.Errorf("orientation %q, want the landscape of the converted screenshot", info.Orientation)
+	}
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	fp "path/filepath"
+
+	"github.com/google/uuid"
+)
+
+// uploads delivers the converted documents, set up by AppStart
+var uploads *UploadQueue
+
+// uploadJob is a pending upload, persisted as <id>.json next to <id>.rmdoc
+type uploadJob struct {
+	ID        string     `json:"id"`
+	Job       string     `json:"job,omitempty"` // Conversion job that produced the document
+	Name      string     `json:"name"`          // File name sent to the web interface
+	Parent    string     `json:"parent"`        // Destination folder ID
+	Sink      SinkConfig `json:"sink"`          // Where the document goes
+	Sources   []string   `json:"sources"`       // Screenshots retained as configured once the upload succeeds
+	Attempts  int        `json:"attempts"`
+	NextTry   time.Time  `json:"next_try"`
+	LastError string     `json:"last_error,omitempty"`
+	Created   time.Time  `json:"created"`
+}
+
+// logger returns the default logger tagged with the upload and conversion job IDs
+func (job *uploadJob) logger() *slog.Logger {
+	logger := slog.With("upload", shortID(job.ID))
+	if job.Job != "" {
+		logger = logger.With("job", shortID(job.Job))
+	}
+	return logger
+}
+
+// UploadQueue is a durable queue of .rmdoc uploads. Jobs survive restarts,
+// failed uploads are retried with exponential backoff, and the source
+// screenshots are only deleted or archived once the document has been delivered.
+type UploadQueue struct {
+	dir         string
+	retryBase   time.Duration
+	retryMax    time.Duration
+	maxAttempts int // 0 retries forever
+
+	config *Config
+
+	// newSink builds the sink of a job, the package newSink outside of tests
+	newSink func(sc SinkConfig, config *Config) (Sink, error)
+
+	mu   sync.Mutex
+	jobs map[string]*uploadJob
+	wake chan struct{}
+}
+
+// NewUploadQueue opens the queue stored in dir, loading the jobs left by a
+// previous run
+func NewUploadQueue(dir string, config *Config) (*UploadQueue, error) {
+	if err := os.MkdirAll(fp.Join(dir, "failed"), 0755); err != nil {
+		return nil, fmt.Errorf("creating upload queue: %w", err)
+	}
+
+	queue := &UploadQueue{
+		dir:     dir,
+		newSink: newSink,
+		jobs:    make(map[string]*uploadJob),
+		wake:    make(chan struct{}, 1),
+	}
+	queue.Configure(config)
+
+	matches, err := fp.Glob(fp.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range matches {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		job := &uploadJob{}
+		if err := json.Unmarshal(data, job); err != nil {
+			slog.Warn("skipping corrupt upload job", "path", path, "err", err)
+			continue
+		}
+		if _, err := os.Stat(queue.rmdocPath(job.ID)); err != nil {
+			slog.Warn("dropping upload job without its document", "upload", shortID(job.ID))
+			os.Remove(path)
+			continue
+		}
+		queue.jobs[job.ID] = job
+	}
+	if len(queue.jobs) > 0 {
+		slog.Info("pending uploads restored", "count", len(queue.jobs))
+	}
+
+	return queue, nil
+}
+
+// Configure applies the upload settings of a (re)loaded config
+func (queue *UploadQueue) Configure(config *Config) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	queue.config = config
+	queue.retryBase = config.RetryBase
+	queue.retryMax = config.RetryMax
+	queue.maxAttempts = config.UploadMaxAttempts
+}
+
+// Enqueue stores the document on disk and schedules its upload
+func (queue *UploadQueue) Enqueue(sink SinkConfig, name string, rmdoc []byte, parent string, sources []string) error {
+	return queue.EnqueueFor("", sink, name, rmdoc, parent, sources)
+}
+
+// EnqueueFor is Enqueue for a document made by a conversion job, which is
+// told when the document has been delivered
+func (queue *UploadQueue) EnqueueFor(conversion string, sink SinkConfig, name string, rmdoc []byte, parent string, sources []string) error {
+	job := &uploadJob{
+		ID:      uuid.NewString(),
+		Job:     conversion,
+		Name:    name,
+		Parent:  parent,
+		Sink:    sink,
+		Sources: sources,
+		NextTry: time.Now(),
+		Created: time.Now(),
+	}
+
+	if err := writeFileSynced(queue.rmdocPath(job.ID), rmdoc); err != nil {
+		return err
+	}
+	if err := queue.save(job); err != nil {
+		os.Remove(queue.rmdocPath(job.ID))
+		return err
+	}
+
+	queue.mu.Lock()
+	queue.jobs[job.ID] = job
+	queue.mu.Unlock()
+
+	queue.notify()
+	return nil
+}
+
+// Jobs returns a copy of the pending jobs, oldest first
+func (queue *UploadQueue) Jobs() []uploadJob {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	jobs := make([]uploadJob, 0, len(queue.jobs))
+	for _, job := range queue.jobs {
+		jobs = append(jobs, *job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Created.Before(jobs[j].Created) })
+	return jobs
+}
+
+// Pending returns the number of jobs waiting to be uploaded
+func (queue *UploadQueue) Pending() int {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	return len(queue.jobs)
+}
+
+// Run uploads the due jobs until stop is closed. An upload in progress is
+// completed; the other jobs stay on disk for the next start.
+func (queue *UploadQueue) Run(stop <-chan struct{}) {
+	for {
+		wait := queue.processDue(stop)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-queue.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// AttemptAll tries every job once, oldest first, whatever its retry time
+func (queue *UploadQueue) AttemptAll() {
+	queue.mu.Lock()
+	jobs := make([]*uploadJob, 0, len(queue.jobs))
+	for _, job := range queue.jobs {
+		jobs = append(jobs, job)
+	}
+	queue.mu.Unlock()
+
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Created.Before(jobs[j].Created) })
+	for _, job := range jobs {
+		queue.attempt(job)
+	}
+}
+
+// processDue tries every job whose retry time has come, oldest first, until
+// stop is closed, and returns how long to wait for the next one
+func (queue *UploadQueue) processDue(stop <-chan struct{}) time.Duration {
+	now := time.Now()
+	for _, job := range queue.due(now) {
+		select {
+		case <-stop:
+			return 0
+		default:
+		}
+		queue.attempt(job)
+	}
+
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	wait := time.Hour
+	for _, job := range queue.jobs {
+		if until := time.Until(job.NextTry); until < wait {
+			wait = until
+		}
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return wait
+}
+
+// Sources returns the screenshots of the pending and failed jobs, which are
+// already taken care of
+func (queue *UploadQueue) Sources() map[string]bool {
+	sources := make(map[string]bool)
+
+	queue.mu.Lock()
+	for _, job := range queue.jobs {
+		for _, source := range job.Sources {
+			sources[source] = true
+		}
+	}
+	queue.mu.Unlock()
+
+	failed, _ := fp.Glob(fp.Join(queue.dir, "failed", "*.json"))
+	for _, path := range failed {
+		job := &uploadJob{}
+		if data, err := os.ReadFile(path); err == nil && json.Unmarshal(data, job) == nil {
+			for _, source := range job.Sources {
+				sources[source] = true
+			}
+		}
+	}
+	return sources
+}
+
+func (queue *UploadQueue) due(now time.Time) []*uploadJob {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	var due []*uploadJob
+	for _, job := range queue.jobs {
+		if !job.NextTry.After(now) {
+			due = append(due, job)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].Created.Before(due[j].Created) })
+	return due
+}
+
+// attempt uploads one job and either completes it or schedules a retry
+func (queue *UploadQueue) attempt(job *uploadJob) {
+	defer runtime.GC()
+
+	queue.mu.Lock()
+	config, buildSink := queue.config, queue.newSink
+	queue.mu.Unlock()
+
+	sink, err := buildSink(job.Sink, config)
+	if err == nil {
+		var rmdoc []byte
+		rmdoc, err = os.ReadFile(queue.rmdocPath(job.ID))
+		if err == nil {
+			start := time.Now()
+			err = sink.Deliver(job.Name, rmdoc, job.Parent)
+			if processing != nil {
+				processing.timed(job.Job, STAGE_UPLOAD, time.Since(start))
+			} else {
+				metrics.observe(STAGE_UPLOAD, time.Since(start))
+			}
+		}
+	}
+	if err == nil {
+		metrics.add("drawj2d_uploads_total", 1)
+		queue.complete(job)
+		return
+	}
+
+	metrics.add("drawj2d_upload_failures_total", 1)
+	queue.mu.Lock()
+	job.Attempts++
+	job.LastError = err.Error()
+	job.NextTry = time.Now().Add(queue.backoff(job.Attempts))
+	giveUp := queue.maxAttempts > 0 && job.Attempts >= queue.maxAttempts
+	queue.mu.Unlock()
+
+	if giveUp {
+		job.logger().Error("giving up upload", "name", job.Name, "attempts", job.Attempts, "err", err)
+		queue.fail(job)
+		return
+	}
+
+	recentErrors.add(job.Name, err)
+	job.logger().Warn("upload failed", "name", job.Name, "attempt", job.Attempts, "retry_at", job.NextTry.Format(time.TimeOnly), "err", err)
+	if err := queue.save(job); err != nil {
+		job.logger().Error("cannot save upload job", "err", err)
+	}
+}
+
+// backoff doubles the retry delay on every attempt, up to retryMax, with
+// up to 20% of jitter so a reconnecting tablet is not hit by a burst
+func (queue *UploadQueue) backoff(attempts int) time.Duration {
+	delay := queue.retryBase
+	for i := 1; i < attempts && delay < queue.retryMax; i++ {
+		delay *= 2
+	}
+	if delay > queue.retryMax {
+		delay = queue.retryMax
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay)/5+1))
+}
+
+// complete removes the job and, the upload being confirmed, its sources. The
+// job stays listed until its sources are in the ledger and retained, so that
+// they are not picked up again in between.
+func (queue *UploadQueue) complete(job *uploadJob) {
+	queue.mu.Lock()
+	config := queue.config
+	queue.mu.Unlock()
+	queue.report(job, JOB_CLEANUP, nil)
+
+	for _, source := range job.Sources {
+		if err := ledger.Record(source); err != nil && !errors.Is(err, os.ErrNotExist) {
+			job.logger().Error("cannot record the screenshot in the ledger", "source", source, "err", err)
+		}
+		if err := retain(config, source); err != nil && !errors.Is(err, os.ErrNotExist) {
+			job.logger().Error("cannot apply the retention policy", "source", source, "retention", config.Retention, "err", err)
+		}
+	}
+	os.Remove(queue.jobPath(job.ID))
+	os.Remove(queue.rmdocPath(job.ID))
+	queue.mu.Lock()
+	delete(queue.jobs, job.ID)
+	queue.mu.Unlock()
+	job.logger().Info("delivered", "name", job.Name, "attempts", job.Attempts+1)
+	queue.report(job, JOB_DONE, nil)
+}
+
+// report tells the conversion job of the upload how delivery went
+func (queue *UploadQueue) report(job *uploadJob, state JobState, err error) {
+	if processing != nil && job.Job != "" {
+		processing.delivered(job.Job, state, err)
+	}
+}
+
+// fail moves the job to the failed directory, keeping its sources
+func (queue *UploadQueue) fail(job *uploadJob) {
+	queue.mu.Lock()
+	delete(queue.jobs, job.ID)
+	queue.mu.Unlock()
+
+	failed := fp.Join(queue.dir, "failed")
+	queue.save(job)
+	os.Rename(queue.jobPath(job.ID), fp.Join(failed, job.ID+".json"))
+	os.Rename(queue.rmdocPath(job.ID), fp.Join(failed, strings.TrimSuffix(job.Name, ".rmdoc")+"-"+job.ID[:8]+".rmdoc"))
+	queue.report(job, JOB_FAILED, errors.New(job.LastError))
+}
+
+func (queue *UploadQueue) save(job *uploadJob) error {
+	queue.mu.Lock()
+	data, err := json.MarshalIndent(job, "", "    ")
+	queue.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileSynced(queue.jobPath(job.ID), data)
+}
+
+func (queue *UploadQueue) notify() {
+	select {
+	case queue.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (queue *UploadQueue) jobPath(id string) string {
+	return fp.Join(queue.dir, id+".json")
+}
+
+func (queue *UploadQueue) rmdocPath(id string) string {
+	return fp.Join(queue.dir, id+".rmdoc")
+}
+
+// writeFileSynced writes the file through a temporary name and fsyncs it, so a
+// power loss leaves either the old or the new contents
+func writeFileSynced(path string, data []byte) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	fp "path/filepath"
+)
+
+// funcSink is a Sink delivering through a function
+type funcSink func(name string, rmdoc []byte, parent string) error
+
+func (sink funcSink) Deliver(name string, rmdoc []byte, parent string) error {
+	return sink(name, rmdoc, parent)
+}
+
+func (sink funcSink) String() string {
+	return "test sink"
+}
+
+// useSink makes every job of the queue go to sink
+func useSink(queue *UploadQueue, sink funcSink) {
+	queue.newSink = func(SinkConfig, *Config) (Sink, error) { return sink, nil }
+}
+
+func testQueueConfig() *Config {
+	config := defaultConfig()
+	config.RetryBase = time.Millisecond
+	config.RetryMax = 5 * time.Millisecond
+	return config
+}
+
+func TestUploadQueueRetriesAndDeletesAfterSuccess(t *testing.T) {
+	dir := t.TempDir()
+	source := fp.Join(dir, "Screenshot.png")
+	os.WriteFile(source, []byte("png"), 0644)
+
+	queue, err := NewUploadQueue(fp.Join(dir, "queue"), testQueueConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	failures := 2
+	useSink(queue, func(name string, rmdoc []byte, parent string) error {
+		if failures > 0 {
+			failures--
+			if _, err := os.Stat(source); err != nil {
+				t.Error("source deleted before a successful upload")
+			}
+			return errors.New("web interface down")
+		}
+		return nil
+	})
+
+	if err := queue.Enqueue(SinkConfig{}, "Screenshot.rmdoc", []byte("rmdoc"), "", []string{source}); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for queue.Pending() > 0 && time.Now().Before(deadline) {
+		wait := queue.processDue(nil)
+		if queue.Pending() > 0 {
+			time.Sleep(wait)
+		}
+	}
+	if queue.Pending() != 0 || failures != 0 {
+		t.Fatalf("pending %d, failures left %d", queue.Pending(), failures)
+	}
+	if _, err := os.Stat(source); !os.IsNotExist(err) {
+		t.Fatal("source kept after a successful upload")
+	}
+}
+
+func TestUploadQueuePersistsAndGivesUp(t *testing.T) {
+	dir := t.TempDir()
+	source := fp.Join(dir, "Screenshot.png")
+	os.WriteFile(source, []byte("png"), 0644)
+
+	config := testQueueConfig()
+	config.UploadMaxAttempts = 1
+
+	queue, _ := NewUploadQueue(fp.Join(dir, "queue"), config)
+	queue.Enqueue(SinkConfig{}, "Screenshot.rmdoc", []byte("rmdoc"), "", []string{source})
+
+	restored, err := NewUploadQueue(fp.Join(dir, "queue"), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Pending() != 1 {
+		t.Fatalf("expected the job to survive a restart, got %d", restored.Pending())
+	}
+
+	useSink(restored, func(name string, rmdoc []byte, parent string) error {
+		return errors.New("rejected")
+	})
+	restored.processDue(nil)
+
+	failed, _ := fp.Glob(fp.Join(dir, "queue", "failed", "*.rmdoc"))
+	if restored.Pending() != 0 || len(failed) != 1 {
+		t.Fatalf("expected the job set aside, pending %d, failed %d", restored.Pending(), len(failed))
+	}
+	if _, err := os.Stat(source); err != nil {
+		t.Fatal("source deleted after a failed upload")
+	}
+}
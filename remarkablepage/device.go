@@ -0,0 +1,110 @@
+package remarkablepage
+
+import (
+	"fmt"
+	"image/color"
+	"slices"
+	"sort"
+)
+
+// Inks of the .rm format, by the colour index stored with every line
+var PEN_COLORS = map[string]int32{
+	"black":   0,
+	"grey":    1,
+	"white":   2,
+	"yellow":  3,
+	"green":   4,
+	"pink":    5,
+	"blue":    6,
+	"red":     7,
+	"cyan":    11,
+	"magenta": 12,
+}
+
+// Device is the page geometry and inks of a tablet model
+type Device struct {
+	Name          string
+	Width, Height float32  // Portrait page size in screen pixels, one pixel per .rm unit
+	Colors        []string // Inks the tablet can show, keys of PEN_COLORS
+}
+
+// Tablet models
+var (
+	DEVICE_RM1 = Device{
+		Name:   "rm1",
+		Width:  X_MAX,
+		Height: Y_MAX,
+		Colors: []string{"black", "grey", "white"},
+	}
+	DEVICE_RM2 = Device{
+		Name:   "rm2",
+		Width:  X_MAX,
+		Height: Y_MAX,
+		Colors: []string{"black", "grey", "white"},
+	}
+	DEVICE_PAPER_PRO = Device{
+		Name:   "paper_pro",
+		Width:  1620,
+		Height: 2160,
+		Colors: []string{"black", "grey", "white", "yellow", "green", "pink", "blue", "red", "cyan", "magenta"},
+	}
+)
+
+// Devices lists the tablet models by name
+var Devices = map[string]Device{
+	DEVICE_RM1.Name:       DEVICE_RM1,
+	DEVICE_RM2.Name:       DEVICE_RM2,
+	DEVICE_PAPER_PRO.Name: DEVICE_PAPER_PRO,
+}
+
+// DeviceNames returns the names of the tablet models in alphabetical order
+func DeviceNames() []string {
+	names := make([]string, 0, len(Devices))
+	for name := range Devices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseDevice returns the tablet model called name
+func ParseDevice(name string) (Device, error) {
+	device, ok := Devices[name]
+	if !ok {
+		return Device{}, fmt.Errorf("unknown device %q, expected one of %v", name, DeviceNames())
+	}
+	return device, nil
+}
+
+// orDefault returns the rM2 for the zero Device
+func (device Device) orDefault() Device {
+	if device.Name == "" {
+		return DEVICE_RM2
+	}
+	return device
+}
+
+// ColorIndex returns the .rm colour index of an ink of the device, black for ""
+func (device Device) ColorIndex(name string) (int32, error) {
+	device = device.orDefault()
+	if name == "" {
+		name = "black"
+	}
+	if !slices.Contains(device.Colors, name) {
+		return 0, fmt.Errorf("the %s has no %q ink, expected one of %v", device.Name, name, device.Colors)
+	}
+	return PEN_COLORS[name], nil
+}
+
+// inkShade is the grey level an ink is rendered with
+func inkShade(index int32) color.Gray {
+	switch index {
+	case PEN_COLORS["white"]:
+		return color.Gray{Y: 255}
+	case PEN_COLORS["grey"]:
+		return color.Gray{Y: 128}
+	case PEN_COLORS["yellow"], PEN_COLORS["cyan"]:
+		return color.Gray{Y: 192}
+	}
+	return color.Gray{Y: 0}
+}
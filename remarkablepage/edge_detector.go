@@ -1,16 +1,12 @@
 package remarkablepage
 
 import (
-	"fmt"
 	"image"
+	"log/slog"
 	"path/filepath"
 	"strings"
 )
 
-const (
-	debug = false
-)
-
 func GetFileNameWithoutExtension(filePath string) string {
 	// Get the base name of the file
 	base := filepath.Base(filePath)
@@ -68,17 +64,10 @@ func BuildBooleanMatrix(img *image.Gray) [][]bool {
 }
 
 func LaplacianEdgeDetection(imagePath string) []byte {
-
-	dir, filep := filepath.Dir(imagePath), filepath.Base(imagePath)
-
-	horLines := HandleNewFile(dir, filep)
-
-	return DrawLines(horLines, float32(X_MAX), float32(Y_MAX))
-
-}
-
-func DebugPrint(info string, opt ...error) {
-	if debug {
-		fmt.Println(info, opt)
+	rmData, err := ConvertImage(imagePath, MODE_EDGES)
+	if err != nil {
+		slog.Error("conversion failed", "path", imagePath, "err", err)
+		return nil
 	}
+	return rmData
 }
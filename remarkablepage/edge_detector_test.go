@@ -10,7 +10,7 @@ func TestBooleanMatrixBuilding(t *testing.T) {
 	imgpath := "/home/nieva/Proyectos/PoC-drawj2d-port-go/images/Screenshot.png"
 
 	rmRawData := LaplacianEdgeDetection(imgpath)
-	zipData, zipName := CreateRmDoc("/home/nieva/Proyectos/PoC-drawj2d-port-go/TestBooleanMatrix", [][]byte{rmRawData})
+	zipData, zipName, _ := CreateRmDoc("/home/nieva/Proyectos/PoC-drawj2d-port-go/TestBooleanMatrix", [][]byte{rmRawData})
 
 	file, _ := os.Create(zipName)
 	zipData.WriteTo(file)
@@ -27,7 +27,7 @@ func TestPNGConversion(t *testing.T) {
 	rmFile := GetFileNameWithoutExtension("/home/nieva/Proyectos/drawj2d-rm/test-3-book.png")
 	rmFile = fmt.Sprintf("%s/%s.rm", "/home/nieva/Proyectos/drawj2d-rm/", rmFile)
 
-	zipData, zipName := CreateRmDoc(rmFile, rmData)
+	zipData, zipName, _ := CreateRmDoc(rmFile, rmData)
 
 	file, _ := os.Create(zipName)
 	zipData.WriteTo(file)
@@ -84,7 +84,7 @@ func TestDrawingPixel(t *testing.T) {
 	}
 
 	rawData := page.Export()
-	zipData, zipName := CreateRmDoc(rmFile, rawData)
+	zipData, zipName, _ := CreateRmDoc(rmFile, rawData)
 	file, _ := os.Create(zipName)
 	zipData.WriteTo(file)
 
@@ -0,0 +1,159 @@
+package remarkablepage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// XOCHITL_DIR is where the tablet keeps the documents of the library
+const XOCHITL_DIR = "/home/root/.local/share/remarkable/xochitl"
+
+// folderMetadata is the subset of a .metadata file needed to walk the folder tree
+type folderMetadata struct {
+	Deleted     bool   `json:"deleted"`
+	Parent      string `json:"parent"`
+	Type        string `json:"type"`
+	VisibleName string `json:"visibleName"`
+}
+
+// ErrNoFolder tells that a folder of the path does not exist yet
+var ErrNoFolder = errors.New("folder does not exist")
+
+// ResolveFolder returns the ID of the destination folder given either its ID or
+// a slash separated path of visible names such as "Work/Notes". Missing folders
+// along the path are created as CollectionType entries in xochitlDir, created
+// telling so: xochitl only picks them up after a restart.
+func ResolveFolder(xochitlDir, folder string) (id string, created bool, err error) {
+	return resolveFolder(xochitlDir, folder, true)
+}
+
+// LookupFolder is ResolveFolder without side effects: it fails with
+// ErrNoFolder rather than creating the missing folders
+func LookupFolder(xochitlDir, folder string) (string, error) {
+	id, _, err := resolveFolder(xochitlDir, folder, false)
+	return id, err
+}
+
+func resolveFolder(xochitlDir, folder string, create bool) (id string, created bool, err error) {
+	folder = strings.Trim(folder, "/")
+	if folder == "" {
+		return "", false, nil
+	}
+
+	folders, err := readFolders(xochitlDir)
+	if err != nil {
+		return "", false, err
+	}
+
+	if _, err := uuid.Parse(folder); err == nil {
+		if _, ok := folders[folder]; ok {
+			return folder, false, nil
+		}
+		return "", false, fmt.Errorf("folder %s not found in %s", folder, xochitlDir)
+	}
+
+	parent := ""
+	for _, name := range strings.Split(folder, "/") {
+		if name == "" {
+			continue
+		}
+		id := findFolder(folders, parent, name)
+		if id == "" && !create {
+			return "", false, fmt.Errorf("%w: %s in %s", ErrNoFolder, name, folder)
+		}
+		if id == "" {
+			id, err = createFolder(xochitlDir, name, parent)
+			if err != nil {
+				return "", created, err
+			}
+			folders[id] = folderMetadata{Parent: parent, Type: "CollectionType", VisibleName: name}
+			created = true
+			slog.Debug("folder created", "name", name, "id", id)
+		}
+		parent = id
+	}
+
+	return parent, created, nil
+}
+
+// readFolders loads every live folder of the library indexed by ID
+func readFolders(xochitlDir string) (map[string]folderMetadata, error) {
+	entries, err := os.ReadDir(xochitlDir)
+	if err != nil {
+		return nil, fmt.Errorf("reading library %s: %w", xochitlDir, err)
+	}
+
+	folders := make(map[string]folderMetadata)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || filepath.Ext(name) != ".metadata" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(xochitlDir, name))
+		if err != nil {
+			return nil, err
+		}
+		var meta folderMetadata
+		if err := json.Unmarshal(data, &meta); err != nil {
+			slog.Debug("skipping unreadable metadata", "file", name, "err", err)
+			continue
+		}
+		if meta.Type == "CollectionType" && !meta.Deleted && meta.Parent != "trash" {
+			folders[strings.TrimSuffix(name, ".metadata")] = meta
+		}
+	}
+
+	return folders, nil
+}
+
+func findFolder(folders map[string]folderMetadata, parent, name string) string {
+	for id, meta := range folders {
+		if meta.Parent == parent && meta.VisibleName == name {
+			return id
+		}
+	}
+	return ""
+}
+
+// createFolder writes the .metadata and .content files of a new folder
+func createFolder(xochitlDir, name, parent string) (string, error) {
+	id := uuid.NewString()
+	now := time.Now().Unix()
+
+	metadata := struct {
+		CreatedTime  int64  `json:"createdTime"`
+		LastModified int64  `json:"lastModified"`
+		Parent       string `json:"parent"`
+		Pinned       bool   `json:"pinned"`
+		Type         string `json:"type"`
+		VisibleName  string `json:"visibleName"`
+	}{
+		CreatedTime:  now,
+		LastModified: now,
+		Parent:       parent,
+		Pinned:       false,
+		Type:         "CollectionType",
+		VisibleName:  name,
+	}
+
+	metadataJSON, err := json.MarshalIndent(metadata, "", "    ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(xochitlDir, id+".metadata"), metadataJSON, 0644); err != nil {
+		return "", fmt.Errorf("creating folder %s: %w", name, err)
+	}
+	if err := os.WriteFile(filepath.Join(xochitlDir, id+".content"), []byte("{\n    \"tags\": []\n}\n"), 0644); err != nil {
+		return "", fmt.Errorf("creating folder %s: %w", name, err)
+	}
+
+	return id, nil
+}
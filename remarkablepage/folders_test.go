@@ -0,0 +1,52 @@
+package remarkablepage
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestResolveFolderCreatesPath(t *testing.T) {
+	dir := t.TempDir()
+
+	id, created, err := ResolveFolder(dir, "Work/Notes")
+	if err != nil || !created {
+		t.Fatal(created, err)
+	}
+
+	folders, err := readFolders(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(folders) != 2 || folders[id].VisibleName != "Notes" {
+		t.Fatalf("unexpected folders: %+v", folders)
+	}
+
+	again, created, err := ResolveFolder(dir, "Work/Notes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != id || created {
+		t.Fatalf("expected existing folder %s, got %s", id, again)
+	}
+
+	byID, _, err := ResolveFolder(dir, id)
+	if err != nil || byID != id {
+		t.Fatalf("resolving by ID: %s, %v", byID, err)
+	}
+}
+
+func TestLookupFolderCreatesNothing(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := LookupFolder(dir, "Work/Notes"); !errors.Is(err, ErrNoFolder) {
+		t.Fatalf("expected ErrNoFolder, got %v", err)
+	}
+	if folders, _ := readFolders(dir); len(folders) != 0 {
+		t.Fatalf("lookup created folders: %+v", folders)
+	}
+
+	id, _, _ := ResolveFolder(dir, "Work/Notes")
+	if found, err := LookupFolder(dir, "Work/Notes"); err != nil || found != id {
+		t.Fatalf("lookup found %s, %v, want %s", found, err, id)
+	}
+}
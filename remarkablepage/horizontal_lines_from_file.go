@@ -8,6 +8,9 @@ package remarkablepage
 */
 import "C"
 import (
+	"fmt"
+	"path/filepath"
+	"time"
 	"unsafe"
 )
 
@@ -18,17 +21,41 @@ type LineList struct {
 
 const maxSize = 1 << 28 // 2^(28)
 
-func HandleNewFile(directory, filename string) LineList {
+func HandleNewFile(directory, filename string) (LineList, error) {
+	lines, _, err := HandleNewFileTimed(directory, filename)
+	return lines, err
+}
+
+// HandleNewFileTimed is HandleNewFile also returning the duration of the
+// load, blur, laplace, matrix and runs stages
+func HandleNewFileTimed(directory, filename string) (LineList, []StageTiming, error) {
 	dir := C.CString(directory)
 	file := C.CString(filename)
 	defer C.free(unsafe.Pointer(dir))
 	defer C.free(unsafe.Pointer(file))
 
 	// Ensure ll is properly allocated and not moved by GC
-	ll := C.handle_new_file(dir, file)
+	var times C.StageTimes
+	ll := C.handle_new_file_timed(dir, file, &times)
+	if ll.size < 0 {
+		return LineList{}, nil, fmt.Errorf("cannot process image %s", filepath.Join(directory, filename))
+	}
+	ms := func(value C.double) time.Duration {
+		return time.Duration(float64(value) * float64(time.Millisecond))
+	}
+	stages := []StageTiming{
+		{STAGE_LOAD, ms(times.load_ms)},
+		{STAGE_BLUR, ms(times.blur_ms)},
+		{STAGE_LAPLACE, ms(times.laplace_ms)},
+		{STAGE_MATRIX, ms(times.matrix_ms)},
+		{STAGE_RUNS, ms(times.runs_ms)},
+	}
 	defer C.free(unsafe.Pointer(ll.lines))
 
 	size := int(ll.size)
+	if size == 0 {
+		return LineList{}, stages, nil
+	}
 	if size > maxSize {
 		size = maxSize
 	}
@@ -37,5 +64,5 @@ func HandleNewFile(directory, filename string) LineList {
 	lines := make([]float32, size*4)
 	copy(lines, (*[maxSize]float32)(unsafe.Pointer(ll.lines))[:size*4:size*4])
 
-	return LineList{Lines: lines, Size: size}
+	return LineList{Lines: lines, Size: size}, stages, nil
 }
@@ -10,7 +10,17 @@ typedef struct {
     int size;
 } LineList;
 
+// Milliseconds spent in each stage of handle_new_file_timed
+typedef struct {
+    double load_ms;
+    double blur_ms;
+    double laplace_ms;
+    double matrix_ms;
+    double runs_ms;
+} StageTimes;
+
 LineList handle_new_file(const char *directory, const char *filename);
+LineList handle_new_file_timed(const char *directory, const char *filename, StageTimes *times);
 
 
 #endif
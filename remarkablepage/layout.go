@@ -0,0 +1,226 @@
+package remarkablepage
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"time"
+)
+
+// Layout places the strokes of an image on the pages of a device
+type Layout struct {
+	Device    Device  // Tablet the pages are made for, the rM2 when zero
+	Color     string  // Ink of the strokes, one of the device colours, black when ""
+	Landscape bool    // Pages held sideways, as high as the device is wide
+	Tile      bool    // Cut the image into as many pages as it needs rather than fitting it on one
+	Scale     float32 // Page pixels per image pixel when tiling, 0 to fit the width of the image to the page
+	Overlap   float32 // Page pixels repeated on both sides of the edge between two tiles
+}
+
+// PageSize returns the size of the pages as the reader sees them
+func (layout Layout) PageSize() (width, height float32) {
+	device := layout.Device.orDefault()
+	if layout.Landscape {
+		return device.Height, device.Width
+	}
+	return device.Width, device.Height
+}
+
+// Grid returns the scale and the number of tile columns and rows covering an
+// image of width by height pixels. Without tiling the image is scaled to fit
+// one page, so a screenshot of another tablet model fills the page.
+func (layout Layout) Grid(width, height int) (scale float32, cols, rows int) {
+	pageWidth, pageHeight := layout.PageSize()
+	if !layout.Tile {
+		return min(pageWidth/float32(width), pageHeight/float32(height)), 1, 1
+	}
+	scale = layout.Scale
+	if scale <= 0 {
+		scale = pageWidth / float32(width)
+	}
+	count := func(size float32, page float32) int {
+		step := page - layout.Overlap
+		return max(1, int(math.Ceil(float64((size-layout.Overlap)/step))))
+	}
+	return scale, count(float32(width)*scale, pageWidth), count(float32(height)*scale, pageHeight)
+}
+
+// validate checks that the overlap leaves room for the tiles to advance and
+// returns the colour index of the ink
+func (layout Layout) validate() (int32, error) {
+	if layout.Scale < 0 {
+		return 0, fmt.Errorf("tiling scale %g must not be negative", layout.Scale)
+	}
+	if limit := layout.Device.orDefault().Width / 2; layout.Overlap < 0 || layout.Overlap >= limit {
+		return 0, fmt.Errorf("tiling overlap %g must be between 0 and %g", layout.Overlap, limit)
+	}
+	return layout.Device.ColorIndex(layout.Color)
+}
+
+// ImageIsWide tells whether the image is wider than it is high, which suits
+// a landscape page
+func ImageIsWide(imagePath string) (bool, error) {
+	width, height, err := imageSize(imagePath)
+	return width > height, err
+}
+
+// ConvertImageLayout runs the pipeline of the mode on the image and lays its
+// strokes out on the pages of the device: tiles are returned in reading
+// order, left to right then top to bottom, and the strokes of landscape pages
+// are turned into the portrait coordinates of the .rm format
+func ConvertImageLayout(imagePath string, mode Mode, layout Layout) ([][]byte, ConversionStats, error) {
+	var stats ConversionStats
+	ink, err := layout.validate()
+	if err != nil {
+		return nil, stats, err
+	}
+	width, height, err := imageSize(imagePath)
+	if err != nil {
+		return nil, stats, err
+	}
+
+	lines, polylines, err := extractStrokes(imagePath, mode, &stats)
+	if err != nil {
+		return nil, stats, err
+	}
+	if polylines == nil {
+		polylines = make([][]float32, 0, lines.Size)
+		for i := 0; i < lines.Size; i++ {
+			segment := lines.Lines[i*4 : i*4+4]
+			if segment[0] == segment[2] && segment[1] == segment[3] {
+				segment = segment[:2] // Dot
+			}
+			polylines = append(polylines, segment)
+		}
+	}
+
+	start := time.Now()
+	scale, cols, rows := layout.Grid(width, height)
+	if scale != 1 {
+		for _, polyline := range polylines {
+			for i := range polyline {
+				polyline[i] *= scale
+			}
+		}
+	}
+
+	pageWidth, pageHeight := layout.PageSize()
+	pages := make([][]byte, 0, cols*rows)
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			x0 := float32(col) * (pageWidth - layout.Overlap)
+			y0 := float32(row) * (pageHeight - layout.Overlap)
+			tile := polylines
+			if layout.Tile { // An untiled image is fitted to its page
+				tile = clipPolylines(polylines, x0, y0, x0+pageWidth, y0+pageHeight)
+			}
+			if layout.Landscape {
+				rotateToPortrait(tile, pageHeight)
+			}
+			page := drawPolylines(tile, ink)
+
+			stats.Lines += len(tile)
+			for _, polyline := range tile {
+				stats.Points += len(polyline) / 2
+			}
+			stats.Size += len(page)
+			pages = append(pages, page)
+		}
+	}
+	stats.Stages = append(stats.Stages, StageTiming{STAGE_DRAW, time.Since(start)})
+	return pages, stats, nil
+}
+
+// rotateToPortrait moves the points of a landscape page to where the .rm
+// format stores them. The tablet is turned a quarter counterclockwise to read
+// landscape pages, so their top left corner is the top right of the screen.
+// The height of a landscape page is the width of the device.
+func rotateToPortrait(polylines [][]float32, height float32) {
+	for _, polyline := range polylines {
+		for i := 0; i+1 < len(polyline); i += 2 {
+			x, y := polyline[i], polyline[i+1]
+			polyline[i], polyline[i+1] = height-y, x
+		}
+	}
+}
+
+// imageSize reads the dimensions of an image without decoding it
+func imageSize(imagePath string) (width, height int, err error) {
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return 0, 0, fmt.Errorf("decoding %s: %w", imagePath, err)
+	}
+	return config.Width, config.Height, nil
+}
+
+// clipPolylines returns the parts of the polylines inside the rectangle
+// (x0, y0)-(x1, y1), moved so that (x0, y0) is the corner of the page
+func clipPolylines(polylines [][]float32, x0, y0, x1, y1 float32) [][]float32 {
+	var clipped [][]float32
+	for _, polyline := range polylines {
+		if len(polyline) == 2 {
+			if x := polyline[0]; x >= x0 && x < x1 {
+				if y := polyline[1]; y >= y0 && y < y1 {
+					clipped = append(clipped, []float32{x - x0, y - y0})
+				}
+			}
+			continue
+		}
+
+		var current []float32
+		for i := 0; i+3 < len(polyline); i += 2 {
+			ax, ay, bx, by, ok := clipSegment(polyline[i], polyline[i+1], polyline[i+2], polyline[i+3], x0, y0, x1, y1)
+			if !ok {
+				if current != nil {
+					clipped, current = append(clipped, current), nil
+				}
+				continue
+			}
+			// The segment continues the current part unless it was cut at its start
+			if n := len(current); n == 0 || current[n-2] != ax-x0 || current[n-1] != ay-y0 {
+				if current != nil {
+					clipped = append(clipped, current)
+				}
+				current = []float32{ax - x0, ay - y0}
+			}
+			current = append(current, bx-x0, by-y0)
+		}
+		if current != nil {
+			clipped = append(clipped, current)
+		}
+	}
+	return clipped
+}
+
+// clipSegment clips the segment (ax, ay)-(bx, by) to the rectangle with the
+// Liang-Barsky algorithm, ok being false when nothing of it is inside
+func clipSegment(ax, ay, bx, by, x0, y0, x1, y1 float32) (cax, cay, cbx, cby float32, ok bool) {
+	dx, dy := bx-ax, by-ay
+	t0, t1 := float32(0), float32(1)
+	for _, edge := range [4][2]float32{{-dx, ax - x0}, {dx, x1 - ax}, {-dy, ay - y0}, {dy, y1 - ay}} {
+		p, q := edge[0], edge[1]
+		if p == 0 {
+			if q < 0 {
+				return 0, 0, 0, 0, false
+			}
+			continue
+		}
+		t := q / p
+		if p < 0 {
+			t0 = max(t0, t)
+		} else {
+			t1 = min(t1, t)
+		}
+		if t0 > t1 {
+			return 0, 0, 0, 0, false
+		}
+	}
+	return ax + t0*dx, ay + t0*dy, ax + t1*dx, ay + t1*dy, true
+}
@@ -0,0 +1,195 @@
+package remarkablepage
+
+import (
+	"cmp"
+	"image"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLayoutGrid(t *testing.T) {
+	for _, test := range []struct {
+		layout        Layout
+		width, height int
+		scale         float32
+		cols, rows    int
+	}{
+		{Layout{Tile: true}, 1404, 1872, 1, 1, 1},
+		{Layout{Tile: true}, 702, 2808, 2, 1, 3},                            // Long screenshot, fit to the width
+		{Layout{Tile: true, Overlap: 72}, 702, 2808, 2, 1, 4},               // 5616 page pixels, 1800 per tile
+		{Layout{Tile: true, Scale: 1, Overlap: 4}, 2804, 100, 1, 2, 1},      // Wide poster at its own size
+		{Layout{Tile: true, Landscape: true}, 936, 1404, 2, 1, 2},           // 1872x2808 on 1872x1404 pages
+		{Layout{}, 5000, 5000, 0.2808, 1, 1},                                // Fitted to one page
+		{Layout{Device: DEVICE_PAPER_PRO}, 1404, 1872, 1620.0 / 1404, 1, 1}, // rM2 screenshot filling the Paper Pro page
+		{Layout{Tile: true, Device: DEVICE_PAPER_PRO}, 810, 4320, 2, 1, 4},  // 1620x8640 on 1620x2160 pages
+	} {
+		scale, cols, rows := test.layout.Grid(test.width, test.height)
+		if scale != test.scale || cols != test.cols || rows != test.rows {
+			t.Errorf("%+v on %dx%d: scale %g, %dx%d tiles, want scale %g, %dx%d",
+				test.layout, test.width, test.height, scale, cols, rows, test.scale, test.cols, test.rows)
+		}
+	}
+}
+
+func TestClipPolylines(t *testing.T) {
+	polylines := [][]float32{
+		{50, 50, 150, 50, 150, 150}, // Leaves the tile on its right
+		{10, 10},                    // Dot inside
+		{200, 200, 300, 300},        // Outside
+	}
+	clipped := clipPolylines(polylines, 0, 0, 100, 100)
+	if len(clipped) != 2 {
+		t.Fatalf("clipped to %v", clipped)
+	}
+	if got := clipped[0]; len(got) != 4 || got[0] != 50 || got[2] != 100 || got[3] != 50 {
+		t.Errorf("first polyline clipped to %v", got)
+	}
+
+	// Next tile to the right, the part of the first polyline past x=100
+	clipped = clipPolylines(polylines, 100, 0, 200, 100)
+	if len(clipped) != 1 || len(clipped[0]) != 6 || clipped[0][0] != 0 || clipped[0][5] != 100 {
+		t.Errorf("clipped to %v", clipped)
+	}
+}
+
+func TestConvertImageLayoutTilesInReadingOrder(t *testing.T) {
+	// A tall image, white with a dark bar at the very bottom
+	img := image.NewGray(image.Rect(0, 0, 100, 400))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	for x := 10; x < 90; x++ {
+		img.Pix[395*img.Stride+x] = 0
+	}
+	path := filepath.Join(t.TempDir(), "tall.png")
+	file, _ := os.Create(path)
+	png.Encode(file, img)
+	file.Close()
+
+	pages, stats, err := ConvertImageLayout(path, MODE_FILL, Layout{Tile: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 3 {
+		t.Fatalf("%d pages, want 3", len(pages))
+	}
+	for i, data := range pages {
+		page, err := ParsePage(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines, _ := page.Stats()
+		if want := map[bool]int{true: 1, false: 0}[i == 2]; lines != want {
+			t.Errorf("page %d has %d lines, want %d", i+1, lines, want)
+		}
+	}
+	if stats.Lines != 1 {
+		t.Errorf("stats count %d lines", stats.Lines)
+	}
+
+	if _, _, err := ConvertImageLayout(path, MODE_FILL, Layout{Tile: true, Overlap: X_MAX}); err == nil {
+		t.Error("overlap as large as the page accepted")
+	}
+}
+
+func TestConvertImageLayoutRotatesLandscapePages(t *testing.T) {
+	// A wide image with one dark pixel near its top left corner, fitted to
+	// the landscape page at twice its size
+	img := image.NewGray(image.Rect(0, 0, 936, 468))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	img.Pix[5*img.Stride+10] = 0
+	path := filepath.Join(t.TempDir(), "wide.png")
+	file, _ := os.Create(path)
+	png.Encode(file, img)
+	file.Close()
+
+	if wide, err := ImageIsWide(path); !wide || err != nil {
+		t.Fatalf("ImageIsWide = %v, %v", wide, err)
+	}
+	pages, _, err := ConvertImageLayout(path, MODE_FILL, Layout{Landscape: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := ParsePage(pages[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.lines) != 1 {
+		t.Fatalf("%d lines, want the dot", len(page.lines))
+	}
+	if point := page.lines[0].pointList[0]; point.x != X_MAX-10 || point.y != 20 {
+		t.Errorf("dot at %g,%g, want %g,20", point.x, point.y, X_MAX-10)
+	}
+}
+
+// writeCornerImage writes a white screenshot of the given size with one dark
+// pixel near its bottom right corner
+func writeCornerImage(t *testing.T, width, height int) string {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	img.Pix[(height-4)*img.Stride+width-4] = 0
+	path := filepath.Join(t.TempDir(), "corner.png")
+	file, _ := os.Create(path)
+	png.Encode(file, img)
+	file.Close()
+	return path
+}
+
+func TestConvertImageLayoutUsesDevice(t *testing.T) {
+	// Screenshots of either tablet are scaled to the page of the other one
+	rm2 := writeCornerImage(t, 1404, 1872)
+	pro := writeCornerImage(t, 1620, 2160)
+	for _, test := range []struct {
+		path   string
+		layout Layout
+		x, y   float32
+	}{
+		{rm2, Layout{Device: DEVICE_RM2, Color: "black"}, 1400, 1868},
+		{rm2, Layout{Device: DEVICE_PAPER_PRO, Color: "blue"}, 1400 * 1620.0 / 1404, 1868 * 1620.0 / 1404},
+		{pro, Layout{Device: DEVICE_RM2}, 1616 * 1404.0 / 1620, 2156 * 1404.0 / 1620},
+		{pro, Layout{Device: DEVICE_PAPER_PRO}, 1616, 2156},
+	} {
+		pages, _, err := ConvertImageLayout(test.path, MODE_FILL, test.layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, err := ParsePage(pages[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.lines) != 1 {
+			t.Fatalf("%s: %d lines, want the dot", test.layout.Device.Name, len(page.lines))
+		}
+		point := page.lines[0].pointList[0]
+		if math.Abs(float64(point.x-test.x)) > 0.01 || math.Abs(float64(point.y-test.y)) > 0.01 {
+			t.Errorf("%s: dot at %g,%g, want %g,%g", test.layout.Device.Name, point.x, point.y, test.x, test.y)
+		}
+		if color := cmp.Or(test.layout.Color, "black"); page.lines[0].color != PEN_COLORS[color] {
+			t.Errorf("colour index %d, want %s", page.lines[0].color, color)
+		}
+	}
+
+	// The single page conversion fits the image to the rM2
+	data, err := ConvertImage(pro, MODE_FILL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := ParsePage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.lines) != 1 || page.lines[0].pointList[0].x > X_MAX || page.lines[0].pointList[0].y > Y_MAX {
+		t.Errorf("Paper Pro screenshot not fitted to the rM2 page: %+v", page.lines)
+	}
+
+	if _, _, err := ConvertImageLayout(rm2, MODE_FILL, Layout{Device: DEVICE_RM2, Color: "blue"}); err == nil {
+		t.Error("blue ink accepted on the rM2")
+	}
+}
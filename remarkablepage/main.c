@@ -7,6 +7,7 @@
 #include <linux/limits.h>
 #include <stdbool.h>
 #include <omp.h>
+#include <time.h>
 
 
 #define STB_IMAGE_IMPLEMENTATION
@@ -26,7 +27,17 @@ typedef struct
     int size;
 } LineList;
 
+typedef struct
+{
+    double load_ms;
+    double blur_ms;
+    double laplace_ms;
+    double matrix_ms;
+    double runs_ms;
+} StageTimes;
+
 LineList handle_new_file(const char *directory, const char *filename);
+LineList handle_new_file_timed(const char *directory, const char *filename, StageTimes *times);
 void apply_gaussian_blur(unsigned char *image, int width, int height);
 void apply_laplace_filter(unsigned char *image, unsigned char *output, int width, int height);
 bool **build_boolean_matrix(unsigned char *image, int width, int height);
@@ -35,69 +46,96 @@ LineList GetHorizontalLines(bool **pointMatrix, int imageWidth, int imageHeight)
 
 
 
-LineList handle_new_file(const char *directory, const char *filename)
+static double now_ms(void)
 {
-    LineList horizontalLines ;
-    if (strncmp(filename, "Screenshot", 10) == 0)
-    {
-        char filepath[PATH_MAX];
-        snprintf(filepath, PATH_MAX, "%s/%s", directory, filename);
-       
-        int width, height, channels;
-        unsigned char *image = stbi_load(filepath, &width, &height, &channels, STBI_grey); // Load as grayscale (1 channel)
-        if (!image)
-        {
-            fprintf(stderr, "Error loading image %s\n", filepath);
-            return horizontalLines;
-        }
-
-        unsigned char *output = (unsigned char *)malloc(width * height);
-        if (!output)
-        {
-            fprintf(stderr, "Error allocating memory for output image\n");
-            stbi_image_free(image);
-            return horizontalLines;
-        }
+    struct timespec ts;
+    clock_gettime(CLOCK_MONOTONIC, &ts);
+    return ts.tv_sec * 1000.0 + ts.tv_nsec / 1e6;
+}
 
-        
-        apply_gaussian_blur(image, width, height);
+LineList handle_new_file(const char *directory, const char *filename)
+{
+    return handle_new_file_timed(directory, filename, NULL);
+}
 
-        apply_laplace_filter(image, output, width, height);
+// handle_new_file_timed is handle_new_file filling times, when not NULL,
+// with the duration of every stage
+LineList handle_new_file_timed(const char *directory, const char *filename, StageTimes *times)
+{
+    // size -1 tells the caller the image could not be processed
+    LineList horizontalLines = {NULL, -1};
+    StageTimes ignored;
+    if (!times)
+    {
+        times = &ignored;
+    }
+    double start = now_ms();
+
+    char filepath[PATH_MAX];
+    snprintf(filepath, PATH_MAX, "%s/%s", directory, filename);
+   
+    int width, height, channels;
+    unsigned char *image = stbi_load(filepath, &width, &height, &channels, STBI_grey); // Load as grayscale (1 channel)
+    if (!image)
+    {
+        fprintf(stderr, "Error loading image %s\n", filepath);
+        return horizontalLines;
+    }
+    times->load_ms = now_ms() - start;
 
-        bool **bool_matrix = build_boolean_matrix(output, width, height);
-        if (!bool_matrix)
-        {
-            fprintf(stderr, "Error creating boolean matrix\n");
-            free(output);
-            stbi_image_free(image);
-            return horizontalLines;
-        }
+    unsigned char *output = (unsigned char *)malloc(width * height);
+    if (!output)
+    {
+        fprintf(stderr, "Error allocating memory for output image\n");
+        stbi_image_free(image);
+        return horizontalLines;
+    }
 
+    start = now_ms();
+    apply_gaussian_blur(image, width, height);
+    times->blur_ms = now_ms() - start;
 
-        // Obtener las líneas horizontales
-         horizontalLines = GetHorizontalLines(bool_matrix, width, height);
+    start = now_ms();
+    apply_laplace_filter(image, output, width, height);
+    times->laplace_ms = now_ms() - start;
 
-      /*   // Imprimir las líneas horizontales
-        for (int i = 0; i < horizontalLines.size; ++i)
-        {
-            printf("Line %d: (%.2f, %.2f) to (%.2f, %.2f)\n",
-                   i,
-                   horizontalLines.lines[i * 4],
-                   horizontalLines.lines[i * 4 + 1],
-                   horizontalLines.lines[i * 4 + 2],
-                   horizontalLines.lines[i * 4 + 3]);
-        } */
-
-        // Clean up
-        for (int i = 0; i < width; ++i)
-        {
-            free(bool_matrix[i]);
-        }
-        free(bool_matrix);
+    start = now_ms();
+    bool **bool_matrix = build_boolean_matrix(output, width, height);
+    times->matrix_ms = now_ms() - start;
+    if (!bool_matrix)
+    {
+        fprintf(stderr, "Error creating boolean matrix\n");
         free(output);
         stbi_image_free(image);
         return horizontalLines;
     }
+
+
+    // Obtener las líneas horizontales
+    start = now_ms();
+    horizontalLines = GetHorizontalLines(bool_matrix, width, height);
+    times->runs_ms = now_ms() - start;
+
+  /*   // Imprimir las líneas horizontales
+    for (int i = 0; i < horizontalLines.size; ++i)
+    {
+        printf("Line %d: (%.2f, %.2f) to (%.2f, %.2f)\n",
+               i,
+               horizontalLines.lines[i * 4],
+               horizontalLines.lines[i * 4 + 1],
+               horizontalLines.lines[i * 4 + 2],
+               horizontalLines.lines[i * 4 + 3]);
+    } */
+
+    // Clean up
+    for (int i = 0; i < width; ++i)
+    {
+        free(bool_matrix[i]);
+    }
+    free(bool_matrix);
+    free(output);
+    stbi_image_free(image);
+    return horizontalLines;
 }
 
 void apply_gaussian_blur(unsigned char *image, int width, int height)
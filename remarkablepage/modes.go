@@ -0,0 +1,229 @@
+package remarkablepage
+
+import (
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+)
+
+// inkThreshold separates ink from paper: darker gray levels are drawn
+const inkThreshold = 128
+
+// loadGray decodes a PNG or JPEG image into gray levels
+func loadGray(imagePath string) (*image.Gray, error) {
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", imagePath, err)
+	}
+	if gray, ok := img.(*image.Gray); ok && gray.Rect.Min == (image.Point{}) {
+		return gray, nil
+	}
+
+	bounds := img.Bounds()
+	gray := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			gray.Set(x, y, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return gray, nil
+}
+
+// thresholdMatrix marks the ink pixels of the image, indexed [x][y] like BuildBooleanMatrix
+func thresholdMatrix(img *image.Gray) [][]bool {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	matrix := make([][]bool, width)
+	for x := range matrix {
+		matrix[x] = make([]bool, height)
+		for y := 0; y < height; y++ {
+			matrix[x][y] = img.Pix[y*img.Stride+x] < inkThreshold
+		}
+	}
+	return matrix
+}
+
+// ditherMatrix spreads the gray levels with Floyd-Steinberg error diffusion,
+// so photos keep their shading as a density of ink pixels
+func ditherMatrix(img *image.Gray) [][]bool {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	levels := make([]float32, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			levels[y*width+x] = float32(img.Pix[y*img.Stride+x])
+		}
+	}
+
+	matrix := make([][]bool, width)
+	for x := range matrix {
+		matrix[x] = make([]bool, height)
+	}
+
+	spread := func(x, y int, err float32) {
+		if x >= 0 && x < width && y < height {
+			levels[y*width+x] += err
+		}
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			old := levels[y*width+x]
+			var value float32 = 255
+			if old < inkThreshold {
+				value = 0
+				matrix[x][y] = true
+			}
+			err := old - value
+			spread(x+1, y, err*7/16)
+			spread(x-1, y+1, err*3/16)
+			spread(x, y+1, err*5/16)
+			spread(x+1, y+1, err*1/16)
+		}
+	}
+	return matrix
+}
+
+// horizontalRuns turns every row of marked pixels into segments, the Go
+// counterpart of GetHorizontalLines in main.c
+func horizontalRuns(matrix [][]bool) LineList {
+	width := len(matrix)
+	if width == 0 {
+		return LineList{}
+	}
+	height := len(matrix[0])
+
+	var lines []float32
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if !matrix[x][y] {
+				continue
+			}
+			from := x
+			for x+1 < width && matrix[x+1][y] {
+				x++
+			}
+			lines = append(lines, float32(from), float32(y), float32(x), float32(y))
+		}
+	}
+	return LineList{Lines: lines, Size: len(lines) / 4}
+}
+
+// thin reduces the marked shapes to one pixel wide skeletons with the
+// Zhang-Suen algorithm
+func thin(matrix [][]bool) {
+	width := len(matrix)
+	if width == 0 {
+		return
+	}
+	height := len(matrix[0])
+
+	at := func(x, y int) int {
+		if x < 0 || y < 0 || x >= width || y >= height || !matrix[x][y] {
+			return 0
+		}
+		return 1
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for step := 0; step < 2; step++ {
+			var remove [][2]int
+			for x := 0; x < width; x++ {
+				for y := 0; y < height; y++ {
+					if !matrix[x][y] {
+						continue
+					}
+					// Neighbours clockwise from north
+					p := [8]int{at(x, y-1), at(x+1, y-1), at(x+1, y), at(x+1, y+1), at(x, y+1), at(x-1, y+1), at(x-1, y), at(x-1, y-1)}
+					neighbours, transitions := 0, 0
+					for i := 0; i < 8; i++ {
+						neighbours += p[i]
+						if p[i] == 0 && p[(i+1)%8] == 1 {
+							transitions++
+						}
+					}
+					if neighbours < 2 || neighbours > 6 || transitions != 1 {
+						continue
+					}
+					if step == 0 && (p[0]*p[2]*p[4] != 0 || p[2]*p[4]*p[6] != 0) {
+						continue
+					}
+					if step == 1 && (p[0]*p[2]*p[6] != 0 || p[0]*p[4]*p[6] != 0) {
+						continue
+					}
+					remove = append(remove, [2]int{x, y})
+				}
+			}
+			for _, point := range remove {
+				matrix[point[0]][point[1]] = false
+			}
+			changed = changed || len(remove) > 0
+		}
+	}
+}
+
+// tracePolylines follows the skeleton pixels into polylines of x, y pairs,
+// consuming the matrix
+func tracePolylines(matrix [][]bool) [][]float32 {
+	width := len(matrix)
+	if width == 0 {
+		return nil
+	}
+	height := len(matrix[0])
+
+	next := func(x, y int) (int, int, bool) {
+		for _, d := range [8][2]int{{1, 0}, {0, 1}, {-1, 0}, {0, -1}, {1, 1}, {-1, 1}, {-1, -1}, {1, -1}} {
+			nx, ny := x+d[0], y+d[1]
+			if nx >= 0 && ny >= 0 && nx < width && ny < height && matrix[nx][ny] {
+				return nx, ny, true
+			}
+		}
+		return 0, 0, false
+	}
+
+	var polylines [][]float32
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if !matrix[x][y] {
+				continue
+			}
+			matrix[x][y] = false
+			polyline := []float32{float32(x), float32(y)}
+			for cx, cy := x, y; ; {
+				nx, ny, ok := next(cx, cy)
+				if !ok {
+					break
+				}
+				matrix[nx][ny] = false
+				polyline = append(polyline, float32(nx), float32(ny))
+				cx, cy = nx, ny
+			}
+			polylines = append(polylines, polyline)
+		}
+	}
+	return polylines
+}
+
+// DrawPolylines adds every polyline as one stroke to a new page and exports it
+func DrawPolylines(polylines [][]float32) []byte {
+	return drawPolylines(polylines, PEN_COLORS["black"])
+}
+
+// drawPolylines is DrawPolylines with the ink of the given colour index
+func drawPolylines(polylines [][]float32, color int32) []byte {
+	page := NewReMarkablePage()
+	for _, polyline := range polylines {
+		ln := page.AddLine()
+		ln.color = color
+		for i := 0; i+1 < len(polyline); i += 2 {
+			ln.AddPoint(polyline[i], polyline[i+1])
+		}
+	}
+	return page.Export()
+}
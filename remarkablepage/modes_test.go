@@ -0,0 +1,77 @@
+package remarkablepage
+
+import (
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestImage draws a dark cross on a white square with a gray band below
+func writeTestImage(t *testing.T) string {
+	img := image.NewGray(image.Rect(0, 0, 40, 40))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	for i := 5; i < 35; i++ {
+		for w := 18; w < 22; w++ {
+			img.Pix[w*img.Stride+i] = 0 // Horizontal bar
+			img.Pix[i*img.Stride+w] = 0 // Vertical bar
+		}
+	}
+	for y := 36; y < 40; y++ {
+		for x := 0; x < 40; x++ {
+			img.Pix[y*img.Stride+x] = 160
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "cross.png")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, img); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestGoPipelineModes(t *testing.T) {
+	path := writeTestImage(t)
+
+	strokes := make(map[Mode]int)
+	for _, mode := range []Mode{MODE_CENTERLINE, MODE_FILL, MODE_PHOTO} {
+		data, err := ConvertImage(path, mode)
+		if err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		}
+		page, err := ParsePage(data)
+		if err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		}
+		strokes[mode], _ = page.Stats()
+	}
+
+	// The fill has one run per row of the cross, the skeleton a few strokes
+	if strokes[MODE_FILL] != 30 {
+		t.Errorf("fill produced %d strokes, want 30", strokes[MODE_FILL])
+	}
+	if n := strokes[MODE_CENTERLINE]; n == 0 || n > 8 {
+		t.Errorf("centerline produced %d strokes, want a few", n)
+	}
+	// Dithering puts ink in the gray band the threshold leaves blank
+	if strokes[MODE_PHOTO] <= strokes[MODE_FILL] {
+		t.Errorf("photo produced %d strokes, fill %d", strokes[MODE_PHOTO], strokes[MODE_FILL])
+	}
+}
+
+func TestUnknownMode(t *testing.T) {
+	if _, err := ParseMode("sketch"); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+	if mode, err := ParseMode("Photo"); err != nil || mode != MODE_PHOTO {
+		t.Errorf("ParseMode(Photo) = %s, %v", mode, err)
+	}
+}
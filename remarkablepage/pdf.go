@@ -0,0 +1,152 @@
+package remarkablepage
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"regexp"
+	"strconv"
+)
+
+var (
+	pdfPageObject = regexp.MustCompile(`/Type\s*/Page[^s]`)
+	pdfPageCount  = regexp.MustCompile(`/Type\s*/Pages[^>]*?/Count\s+(\d+)|/Count\s+(\d+)[^>]*?/Type\s*/Pages`)
+	pdfRoot       = regexp.MustCompile(`/Root\s+(\d+)\s+\d+\s+R`)
+	pdfPagesRef   = regexp.MustCompile(`/Pages\s+(\d+)\s+\d+\s+R`)
+	pdfCount      = regexp.MustCompile(`/Count\s+(\d+)(\s+\d+\s+R)?`)
+	pdfObject     = regexp.MustCompile(`(?s)(?:^|[^\d])(\d+)\s+\d+\s+obj\b(.*?)endobj`)
+	pdfObjStm     = regexp.MustCompile(`/Type\s*/ObjStm\b`)
+	pdfInt        = regexp.MustCompile(`/(N|First|Length)\s+(\d+)(\s+\d+\s+R)?`)
+)
+
+// CountPDFPages returns the number of pages of a PDF, or 0 when it cannot tell.
+// It reads the /Count of the page tree the catalog points to, looking the
+// objects up in compressed object streams too, as PDF 1.5 and later exporters
+// store them. Damaged PDFs fall back to counting the visible page objects.
+func CountPDFPages(pdf []byte) int {
+	if count := countPageTree(pdf); count > 0 {
+		return count
+	}
+	if count := len(pdfPageObject.FindAllIndex(pdf, -1)); count > 0 {
+		return count
+	}
+
+	count := 0
+	for _, match := range pdfPageCount.FindAllSubmatch(pdf, -1) {
+		for _, group := range match[1:] {
+			if n, err := strconv.Atoi(string(group)); err == nil && n > count {
+				count = n
+			}
+		}
+	}
+	return count
+}
+
+// countPageTree follows the trailer /Root to the root of the page tree and
+// returns its /Count, 0 when any link is missing
+func countPageTree(pdf []byte) int {
+	objects := pdfObjects(pdf)
+
+	// The last trailer or cross-reference stream wins after incremental updates
+	roots := pdfRoot.FindAllSubmatch(pdf, -1)
+	if len(roots) == 0 {
+		return 0
+	}
+	catalog := objects[atoi(roots[len(roots)-1][1])]
+	pages := pdfPagesRef.FindSubmatch(catalog)
+	if pages == nil {
+		return 0
+	}
+	count := pdfCount.FindSubmatch(objects[atoi(pages[1])])
+	if count == nil {
+		return 0
+	}
+	if len(count[2]) > 0 {
+		// Indirect count, the object holds the number
+		value := bytes.TrimSpace(objects[atoi(count[1])])
+		return atoi(value)
+	}
+	return atoi(count[1])
+}
+
+// pdfObjects returns the body of every object of the PDF by number, those of
+// the Flate compressed object streams included. Later definitions win.
+func pdfObjects(pdf []byte) map[int][]byte {
+	objects := make(map[int][]byte)
+	var streams [][]byte
+	for _, match := range pdfObject.FindAllSubmatch(pdf, -1) {
+		body := match[2]
+		objects[atoi(match[1])] = body
+		if pdfObjStm.Match(body) {
+			streams = append(streams, body)
+		}
+	}
+
+	for _, body := range streams {
+		for number, object := range objectStream(body, objects) {
+			if _, ok := objects[number]; !ok {
+				objects[number] = object
+			}
+		}
+	}
+	return objects
+}
+
+// objectStream decodes an object stream: a header of object number and offset
+// pairs, followed from /First on by the objects themselves
+func objectStream(body []byte, objects map[int][]byte) map[int][]byte {
+	dict, data, ok := bytes.Cut(body, []byte("stream"))
+	if !ok {
+		return nil
+	}
+	values := make(map[string]int)
+	for _, match := range pdfInt.FindAllSubmatch(dict, -1) {
+		value := atoi(match[2])
+		if len(match[3]) > 0 {
+			value = atoi(bytes.TrimSpace(objects[value]))
+		}
+		values[string(match[1])] = value
+	}
+
+	// The data starts after the end of line following the keyword
+	data = bytes.TrimPrefix(bytes.TrimPrefix(data, []byte("\r")), []byte("\n"))
+	if length := values["Length"]; length > 0 && length <= len(data) {
+		data = data[:length]
+	}
+	reader, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	decoded, err := io.ReadAll(reader)
+	if err != nil && len(decoded) == 0 {
+		return nil
+	}
+
+	first := values["First"]
+	if first <= 0 || first > len(decoded) {
+		return nil
+	}
+	header := bytes.Fields(decoded[:first])
+	objects = make(map[int][]byte)
+	for i := 0; i+1 < len(header) && i/2 < values["N"]; i += 2 {
+		start := first + atoi(header[i+1])
+		end := len(decoded)
+		if i+3 < len(header) {
+			end = first + atoi(header[i+3])
+		}
+		if start < first || start > end || end > len(decoded) {
+			return objects
+		}
+		objects[atoi(header[i])] = decoded[start:end]
+	}
+	return objects
+}
+
+// atoi parses a PDF integer, -1 when it is not one
+func atoi(s []byte) int {
+	n, err := strconv.Atoi(string(s))
+	if err != nil {
+		return -1
+	}
+	return n
+}
@@ -0,0 +1,32 @@
+package remarkablepage
+
+import (
+	"os"
+	"testing"
+)
+
+func TestCountPDFPagesInObjectStreams(t *testing.T) {
+	// Three pages in a nested page tree, every object but the object and
+	// cross-reference streams compressed, as PDF 1.5 exporters write them
+	pdf, err := os.ReadFile("testdata/objstm.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := CountPDFPages(pdf); n != 3 {
+		t.Errorf("%d pages, want 3", n)
+	}
+}
+
+func TestCountPDFPagesFollowsRoot(t *testing.T) {
+	// The page tree /Count wins over page objects left over from an edit
+	pdf := []byte(`%PDF-1.4
+1 0 obj << /Type /Catalog /Pages 2 0 R >> endobj
+2 0 obj << /Type /Pages /Kids [3 0 R] /Count 1 >> endobj
+3 0 obj << /Type /Page /Parent 2 0 R >> endobj
+4 0 obj << /Type /Page /Parent 2 0 R >> endobj
+trailer << /Size 5 /Root 1 0 R >>
+%%EOF`)
+	if n := CountPDFPages(pdf); n != 1 {
+		t.Errorf("%d pages, want 1", n)
+	}
+}
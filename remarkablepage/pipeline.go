@@ -0,0 +1,129 @@
+package remarkablepage
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Mode selects how an image is turned into strokes
+type Mode string
+
+const (
+	MODE_EDGES      Mode = "edges"      // Gaussian blur and Laplace filter, outlines of every shape
+	MODE_CENTERLINE Mode = "centerline" // Skeleton of the dark shapes, one stroke per line of a drawing
+	MODE_FILL       Mode = "fill"       // Dark areas filled with horizontal strokes
+	MODE_PHOTO      Mode = "photo"      // Dithered gray levels, for pictures
+)
+
+// Modes lists the supported pipeline modes
+var Modes = []Mode{MODE_EDGES, MODE_CENTERLINE, MODE_FILL, MODE_PHOTO}
+
+// ParseMode returns the mode named s
+func ParseMode(s string) (Mode, error) {
+	for _, mode := range Modes {
+		if string(mode) == strings.ToLower(s) {
+			return mode, nil
+		}
+	}
+	return "", fmt.Errorf("unknown pipeline mode %q, expected one of %v", s, Modes)
+}
+
+// Stages of the pipelines, in the order they run
+const (
+	STAGE_LOAD    = "load"
+	STAGE_BLUR    = "blur"
+	STAGE_LAPLACE = "laplace"
+	STAGE_MATRIX  = "matrix" // Boolean matrix: edges, threshold, dithering or skeleton
+	STAGE_RUNS    = "runs"   // Stroke extraction: horizontal runs or skeleton tracing
+	STAGE_DRAW    = "draw"   // .rm serialization
+)
+
+// StageTiming is the time spent in one stage of a pipeline
+type StageTiming struct {
+	Stage    string
+	Duration time.Duration
+}
+
+// ConversionStats describes the conversion of one image
+type ConversionStats struct {
+	Stages []StageTiming
+	Lines  int // Strokes of the page
+	Points int
+	Size   int // Bytes of .rm data
+}
+
+// ConvertImage runs the pipeline of the given mode on the image and returns
+// the .rm data of the resulting page, the image fitted to an rM2 page
+func ConvertImage(imagePath string, mode Mode) ([]byte, error) {
+	data, _, err := ConvertImageStats(imagePath, mode)
+	return data, err
+}
+
+// ConvertImageStats is ConvertImage also returning the timing of every stage
+// and the size of the page
+func ConvertImageStats(imagePath string, mode Mode) ([]byte, ConversionStats, error) {
+	pages, stats, err := ConvertImageLayout(imagePath, mode, Layout{})
+	if err != nil {
+		return nil, stats, err
+	}
+	return pages[0], stats, nil
+}
+
+// extractStrokes runs the pipeline of the mode up to the strokes, in image
+// pixels: segments for the edges, fill and photo modes, polylines for the
+// centerline mode. The stages are added to stats.
+func extractStrokes(imagePath string, mode Mode, stats *ConversionStats) (LineList, [][]float32, error) {
+	if mode == MODE_EDGES {
+		lines, stages, err := HandleNewFileTimed(filepath.Dir(imagePath), filepath.Base(imagePath))
+		stats.Stages = append(stats.Stages, stages...)
+		return lines, nil, err
+	}
+
+	start := time.Now()
+	stage := func(name string) {
+		stats.Stages = append(stats.Stages, StageTiming{name, time.Since(start)})
+		start = time.Now()
+	}
+
+	img, err := loadGray(imagePath)
+	if err != nil {
+		return LineList{}, nil, err
+	}
+	stage(STAGE_LOAD)
+
+	var matrix [][]bool
+	switch mode {
+	case MODE_CENTERLINE:
+		matrix = thresholdMatrix(img)
+		thin(matrix)
+		stage(STAGE_MATRIX)
+		polylines := tracePolylines(matrix)
+		stage(STAGE_RUNS)
+		return LineList{}, polylines, nil
+	case MODE_FILL:
+		matrix = thresholdMatrix(img)
+	case MODE_PHOTO:
+		matrix = ditherMatrix(img)
+	default:
+		return LineList{}, nil, fmt.Errorf("unknown pipeline mode %q", mode)
+	}
+	stage(STAGE_MATRIX)
+	lines := horizontalRuns(matrix)
+	stage(STAGE_RUNS)
+	return lines, nil, nil
+}
+
+// points counts the points DrawLines makes of the segments, one for a dot
+func (lines LineList) points() int {
+	points := 0
+	for i := 0; i < lines.Size; i++ {
+		if lines.Lines[i*4] != lines.Lines[i*4+2] || lines.Lines[i*4+1] != lines.Lines[i*4+3] {
+			points += 2
+		} else {
+			points++
+		}
+	}
+	return points
+}
@@ -0,0 +1,217 @@
+package remarkablepage
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"path"
+	"strings"
+)
+
+// ParsePage reads a version 5 .rm file back into a page
+func ParsePage(data []byte) (*ReMarkablePage, error) {
+	if !bytes.HasPrefix(data, []byte(HEADER_V5)) {
+		return nil, fmt.Errorf("not a version 5 .rm file")
+	}
+	reader := bytes.NewReader(data[len(HEADER_V5):])
+	read := func(v any) error {
+		return binary.Read(reader, binary.LittleEndian, v)
+	}
+
+	page := NewReMarkablePage()
+	var nbLayers int32
+	if err := read(&nbLayers); err != nil {
+		return nil, fmt.Errorf("reading layer count: %w", err)
+	}
+	for layer := int32(0); layer < nbLayers; layer++ {
+		var nbLines int32
+		if err := read(&nbLines); err != nil {
+			return nil, fmt.Errorf("reading line count of layer %d: %w", layer, err)
+		}
+		for i := int32(0); i < nbLines; i++ {
+			line := page.AddLine()
+			var nbPoints int32
+			for _, field := range []any{&line.brushType, &line.color, &line.padding, &line.brushBaseSize, &line.unknownLineAttribute, &nbPoints} {
+				if err := read(field); err != nil {
+					return nil, fmt.Errorf("reading line %d: %w", i, err)
+				}
+			}
+			for j := int32(0); j < nbPoints; j++ {
+				point := &rmPoint{}
+				for _, field := range []any{&point.x, &point.y, &point.speed, &point.direction, &point.width, &point.pressure} {
+					if err := read(field); err != nil {
+						return nil, fmt.Errorf("reading point %d of line %d: %w", j, i, err)
+					}
+				}
+				line.pointList = append(line.pointList, point)
+			}
+		}
+	}
+
+	return page, nil
+}
+
+// Stats returns the number of lines and points of the page
+func (page *ReMarkablePage) Stats() (lines, points int) {
+	page.mu.Lock()
+	defer page.mu.Unlock()
+
+	for _, line := range page.lines {
+		points += len(line.pointList)
+	}
+	return len(page.lines), points
+}
+
+// Render draws the strokes of the page on a white image the size of an rM2 page
+func (page *ReMarkablePage) Render() *image.Gray {
+	return page.RenderSize(X_MAX, Y_MAX)
+}
+
+// RenderSize draws the strokes of the page in shades of grey matching their
+// inks on a white image of width by height pixels
+func (page *ReMarkablePage) RenderSize(width, height float32) *image.Gray {
+	page.mu.Lock()
+	defer page.mu.Unlock()
+
+	img := image.NewGray(image.Rect(0, 0, int(width), int(height)))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+
+	for _, line := range page.lines {
+		shade := inkShade(line.color)
+		for i, point := range line.pointList {
+			if i == 0 {
+				img.SetGray(int(point.x), int(point.y), shade)
+				continue
+			}
+			previous := line.pointList[i-1]
+			drawSegment(img, previous.x, previous.y, point.x, point.y, shade)
+		}
+	}
+
+	return img
+}
+
+// RotateLandscape turns a rendered page the way the tablet shows it in
+// landscape, undoing the rotation of Layout
+func RotateLandscape(img *image.Gray) *image.Gray {
+	bounds := img.Bounds()
+	rotated := image.NewGray(image.Rect(0, 0, bounds.Dy(), bounds.Dx()))
+	for y := 0; y < bounds.Dx(); y++ {
+		for x := 0; x < bounds.Dy(); x++ {
+			rotated.Pix[y*rotated.Stride+x] = img.GrayAt(bounds.Dx()-1-y, x).Y
+		}
+	}
+	return rotated
+}
+
+// drawSegment draws a one pixel wide segment between two points
+func drawSegment(img *image.Gray, x0, y0, x1, y1 float32, shade color.Gray) {
+	steps := int(math.Max(math.Abs(float64(x1-x0)), math.Abs(float64(y1-y0))))
+	if steps == 0 {
+		img.SetGray(int(x0), int(y0), shade)
+		return
+	}
+	for s := 0; s <= steps; s++ {
+		t := float32(s) / float32(steps)
+		img.SetGray(int(x0+(x1-x0)*t+0.5), int(y0+(y1-y0)*t+0.5), shade)
+	}
+}
+
+// RmDocInfo is the decoded content of an .rmdoc archive
+type RmDocInfo struct {
+	ID          string
+	VisibleName string
+	FileType    string
+	Parent      string
+	Pinned      bool
+	Tags        []string
+	PageIDs     []string          // Pages in document order
+	Pages       map[string][]byte // .rm data by page ID, pages without strokes are missing
+	PDFSize     int
+	Orientation string // portrait or landscape
+	PageWidth   int    // Portrait page size of the device the document was made for
+	PageHeight  int
+}
+
+// ReadRmDoc decodes the metadata, content and pages of an .rmdoc archive
+func ReadRmDoc(data []byte) (*RmDocInfo, error) {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("not an .rmdoc archive: %w", err)
+	}
+
+	info := &RmDocInfo{Pages: make(map[string][]byte)}
+	var content, metadata []byte
+	for _, file := range reader.File {
+		body, err := readZipEntry(file)
+		if err != nil {
+			return nil, err
+		}
+		name := path.Base(file.Name)
+		switch path.Ext(name) {
+		case ".content":
+			content = body
+			info.ID = strings.TrimSuffix(name, ".content")
+		case ".metadata":
+			metadata = body
+		case ".pdf":
+			info.PDFSize = len(body)
+		case ".rm":
+			info.Pages[strings.TrimSuffix(name, ".rm")] = body
+		}
+	}
+	if content == nil || metadata == nil {
+		return nil, fmt.Errorf("archive has no .content or .metadata")
+	}
+
+	var meta struct {
+		VisibleName string `json:"visibleName"`
+		Parent      string `json:"parent"`
+		Pinned      bool   `json:"pinned"`
+	}
+	if err := json.Unmarshal(metadata, &meta); err != nil {
+		return nil, fmt.Errorf("reading metadata: %w", err)
+	}
+	info.VisibleName, info.Parent, info.Pinned = meta.VisibleName, meta.Parent, meta.Pinned
+
+	var cont struct {
+		FileType    string       `json:"fileType"`
+		Orientation string       `json:"orientation"`
+		PageWidth   int          `json:"customZoomPageWidth"`
+		PageHeight  int          `json:"customZoomPageHeight"`
+		Tags        []contentTag `json:"tags"`
+		CPages      struct {
+			Pages []contentPage `json:"pages"`
+		} `json:"cPages"`
+	}
+	if err := json.Unmarshal(content, &cont); err != nil {
+		return nil, fmt.Errorf("reading content: %w", err)
+	}
+	info.FileType, info.Orientation = cont.FileType, cont.Orientation
+	info.PageWidth, info.PageHeight = cont.PageWidth, cont.PageHeight
+	for _, tag := range cont.Tags {
+		info.Tags = append(info.Tags, tag.Name)
+	}
+	for _, page := range cont.CPages.Pages {
+		info.PageIDs = append(info.PageIDs, page.ID)
+	}
+
+	return info, nil
+}
+
+func readZipEntry(file *zip.File) ([]byte, error) {
+	rc, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", file.Name, err)
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
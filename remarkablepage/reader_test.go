@@ -0,0 +1,30 @@
+package remarkablepage
+
+import (
+	"testing"
+)
+
+func TestParsePageRoundTrip(t *testing.T) {
+	page := NewReMarkablePage()
+	line := page.AddLine()
+	line.AddPoint(10, 20)
+	line.AddPoint(30, 20)
+	page.AddPixel(100, 100)
+
+	parsed, err := ParsePage(page.Export())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines, points := parsed.Stats(); lines != 2 || points != 5 {
+		t.Fatalf("expected 2 lines and 5 points, got %d and %d", lines, points)
+	}
+
+	img := parsed.Render()
+	if img.GrayAt(20, 20).Y != 0 || img.GrayAt(20, 21).Y != 255 {
+		t.Fatal("segment not rendered where expected")
+	}
+
+	if _, err := ParsePage([]byte("not a page")); err == nil {
+		t.Fatal("expected an error for a bad header")
+	}
+}
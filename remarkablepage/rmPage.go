@@ -3,7 +3,6 @@ package remarkablepage
 import (
 	"bytes"
 	"encoding/binary"
-	"fmt"
 	"image/color"
 	"sync"
 )
@@ -20,7 +19,6 @@ const (
 // ReMarkablePage represents a page for the reMarkable tablet
 type ReMarkablePage struct {
 	lines      []*rmLine
-	debug      bool
 	out        []byte
 	colors     map[string]color.RGBA
 	pageHeight float32
@@ -50,7 +48,6 @@ type rmPoint struct {
 func NewReMarkablePage() *ReMarkablePage {
 	return &ReMarkablePage{
 		lines: make([]*rmLine, 0),
-		debug: false,
 		out:   make([]byte, 0), // Initialize with an empty slice
 		colors: map[string]color.RGBA{
 			"red":   {R: 217, G: 7, B: 7, A: 255},
@@ -75,9 +72,6 @@ func (page *ReMarkablePage) AddLine() *rmLine {
 		unknownLineAttribute: 0.0,
 	}
 	page.lines = append(page.lines, line)
-	if page.debug {
-		fmt.Printf("[RemarkablePage] line added. Nb lines: %d\n", len(page.lines))
-	}
 
 	return line
 }
@@ -3,38 +3,145 @@ package remarkablepage
 import (
 	"archive/zip"
 	"bytes"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// CONTENT_FORMAT_VERSION is the formatVersion of the .content files written,
+// the one xochitl reads on every tablet model
+const CONTENT_FORMAT_VERSION = 2
+
 // ReMarkableAPIrmdoc representa la estructura para empaquetar archivos .rm en un .rmdoc
 type ReMarkableAPIrmdoc struct {
-	Content          string   `json:"content"`
-	NotebookMetadata string   `json:"notebookmetadata"`
-	Metadata0rm      string   `json:"metadata0rm"`
-	Rmdata           [][]byte `json:"-"`
-	Time             int64    `json:"time"`
+	Content          string     `json:"content"`
+	NotebookMetadata string     `json:"notebookmetadata"`
+	Metadata0rm      string     `json:"metadata0rm"`
+	Rmdata           [][]byte   `json:"-"`
+	Time             int64      `json:"time"`
+	Options          DocOptions `json:"-"`
 	internalBuffer   *bytes.Buffer
+	notebookID       string
+	pageIDs          []string
+}
+
+// DocOptions controls where a generated document is filed in the library
+type DocOptions struct {
+	Parent      string           // ID of the destination folder, "" for the library root
+	Pinned      bool             // Show the document in the favourites
+	Tags        []string         // Document tags
+	PageTags    map[int][]string // Tags per page index
+	AllPageTags []string         // Tags of every page, before its own PageTags
+	NewID       IDGenerator      // Generates the notebook and page IDs, random UUIDs when nil
+	IDSeed      []byte           // Seeds a fresh SeededIDs generator for every document, overrides NewID
+	Now         Clock            // Stamps the metadata and zip entries, time.Now when nil
+	PDF         []byte           // Original PDF the pages are overlaid on, nil for a notebook
+	Landscape   bool             // Pages read sideways, see Layout
+	Device      Device           // Tablet the pages are made for, the rM2 when zero
+}
+
+// IDGenerator returns a new document or page ID on every call
+type IDGenerator func() string
+
+// Clock returns the time recorded in the generated document
+type Clock func() time.Time
+
+// DefaultDocOptions returns the options used by CreateRmDoc
+func DefaultDocOptions() DocOptions {
+	return DocOptions{Pinned: true}
+}
+
+// Deterministic returns a copy of opts whose IDs are UUIDv5 values derived from
+// the input hash and whose timestamps are all t, so identical inputs always
+// produce byte-identical .rmdoc files.
+func (opts DocOptions) Deterministic(input []byte, t time.Time) DocOptions {
+	sum := sha256.Sum256(input)
+	opts.IDSeed = sum[:]
+	opts.Now = FixedClock(t)
+	return opts
+}
+
+// SeededIDs returns a generator of name based UUIDs: the n-th call yields the
+// SHA-1 UUID of seed followed by n. It is safe for concurrent use, though the
+// IDs then depend on the order of the calls.
+func SeededIDs(seed []byte) IDGenerator {
+	namespace := uuid.NewSHA1(uuid.NameSpaceOID, seed)
+	var mu sync.Mutex
+	counter := 0
+	return func() string {
+		mu.Lock()
+		n := counter
+		counter++
+		mu.Unlock()
+		return uuid.NewSHA1(namespace, []byte(strconv.Itoa(n))).String()
+	}
+}
+
+// FixedClock returns a clock that is always at t
+func FixedClock(t time.Time) Clock {
+	return func() time.Time { return t }
+}
+
+func (opts DocOptions) newID() string {
+	if opts.NewID == nil {
+		return uuid.NewString()
+	}
+	return opts.NewID()
+}
+
+func (opts DocOptions) now() time.Time {
+	if opts.Now == nil {
+		return time.Now()
+	}
+	return opts.Now()
 }
 
 // NewReMarkableAPIrmdoc crea una nueva instancia de ReMarkableAPIrmdoc
-func NewReMarkableAPIrmdoc(zipfile string, rmdata [][]byte) *ReMarkableAPIrmdoc {
+func NewReMarkableAPIrmdoc(zipfile string, rmdata [][]byte) (*ReMarkableAPIrmdoc, error) {
+	return NewReMarkableAPIrmdocWithOptions(zipfile, rmdata, DefaultDocOptions())
+}
+
+// NewReMarkableAPIrmdocWithOptions crea una instancia de ReMarkableAPIrmdoc con opciones de ubicacion
+func NewReMarkableAPIrmdocWithOptions(zipfile string, rmdata [][]byte, opts DocOptions) (*ReMarkableAPIrmdoc, error) {
+	rmdoc, err := newRmDoc(zipfile, rmdata, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := rmdoc.writeZip(rmdoc.notebookID, rmdoc.pageIDs); err != nil {
+		return nil, err
+	}
+	return rmdoc, nil
+}
+
+// newRmDoc lays the document out, its IDs, content and metadata, without
+// packing it into an archive
+func newRmDoc(zipfile string, rmdata [][]byte, opts DocOptions) (*ReMarkableAPIrmdoc, error) {
+	if opts.IDSeed != nil {
+		opts.NewID = SeededIDs(opts.IDSeed)
+	}
 	rmdoc := &ReMarkableAPIrmdoc{
-		Rmdata: rmdata,
-		Time:   time.Now().Unix(),
+		Rmdata:  rmdata,
+		Time:    opts.now().Unix(),
+		Options: opts,
 	}
-	rmdoc.process(zipfile)
-	return rmdoc
+	if err := rmdoc.process(zipfile); err != nil {
+		return nil, err
+	}
+	return rmdoc, nil
 }
 
-func (rmdoc *ReMarkableAPIrmdoc) process(zipfile string) {
-	notebookID := uuid.NewString()
+func (rmdoc *ReMarkableAPIrmdoc) process(zipfile string) error {
+	notebookID := rmdoc.Options.newID()
 	visibleName := filepath.Base(zipfile)
 	if strings.HasSuffix(visibleName, ".rmdoc") {
 		visibleName = visibleName[:len(visibleName)-len(".rmdoc")]
@@ -43,62 +150,107 @@ func (rmdoc *ReMarkableAPIrmdoc) process(zipfile string) {
 		visibleName = visibleName[len("out-"):]
 	}
 
-	pageIDs := make([]string, len(rmdoc.Rmdata))
+	pageIDs := make([]string, rmdoc.pageCount())
 	for i := range pageIDs {
-		pageIDs[i] = uuid.NewString()
+		pageIDs[i] = rmdoc.Options.newID()
 	}
 
-	rmdoc.Content = rmdoc.createContent(pageIDs)
-	rmdoc.NotebookMetadata = rmdoc.createNotebookMetadata(visibleName)
+	var err error
+	if rmdoc.Content, err = rmdoc.createContent(pageIDs); err != nil {
+		return err
+	}
+	if rmdoc.NotebookMetadata, err = rmdoc.createNotebookMetadata(visibleName); err != nil {
+		return err
+	}
+	rmdoc.notebookID = notebookID
+	rmdoc.pageIDs = pageIDs
+	return nil
+}
 
-	rmdoc.writeZip(notebookID, pageIDs)
+// docEntry is a file of the document, named relative to the library root
+type docEntry struct {
+	name string
+	data []byte
+}
+
+// entries lists the files making up the document, in archive order
+func (rmdoc *ReMarkableAPIrmdoc) entries(notebookID string, pageIDs []string) []docEntry {
+	entries := []docEntry{
+		{name: notebookID + ".content", data: []byte(rmdoc.Content)},
+		{name: notebookID + ".metadata", data: []byte(rmdoc.NotebookMetadata)},
+	}
+	if rmdoc.isPDF() {
+		entries = append(entries, docEntry{name: notebookID + ".pdf", data: rmdoc.Options.PDF})
+	}
+	for i, pageID := range pageIDs {
+		if rmdoc.hasAnnotations(i) {
+			entries = append(entries, docEntry{name: notebookID + "/" + pageID + ".rm", data: rmdoc.Rmdata[i]})
+		}
+	}
+	return entries
 }
 
-func (rmdoc *ReMarkableAPIrmdoc) writeZip(notebookID string, pageIDs []string) {
+func (rmdoc *ReMarkableAPIrmdoc) writeZip(notebookID string, pageIDs []string) error {
 	f := new(bytes.Buffer)
 
 	zipWriter := zip.NewWriter(f)
-	defer zipWriter.Close()
+	for _, entry := range rmdoc.entries(notebookID, pageIDs) {
+		entryFile, err := rmdoc.createEntry(zipWriter, entry.name)
+		if err != nil {
+			return fmt.Errorf("creating zip entry %s: %w", entry.name, err)
+		}
+		if _, err := entryFile.Write(entry.data); err != nil {
+			return fmt.Errorf("writing zip entry %s: %w", entry.name, err)
+		}
+	}
+	if err := zipWriter.Close(); err != nil {
+		return fmt.Errorf("closing zip: %w", err)
+	}
 
-	contentData := []byte(rmdoc.Content)
-	notebookMetadataData := []byte(rmdoc.NotebookMetadata)
+	rmdoc.internalBuffer = f
+	return nil
+}
 
-	// Escribir contenido
-	contentFile, err := zipWriter.Create(notebookID + ".content")
-	if err != nil {
-		log.Fatalf("Error creating zip entry for content: %v", err)
-	}
-	_, err = contentFile.Write(contentData)
-	if err != nil {
-		log.Fatalf("Error writing content to zip entry: %v", err)
+// isPDF tells whether the pages are annotations over an original PDF
+func (rmdoc *ReMarkableAPIrmdoc) isPDF() bool {
+	return rmdoc.Options.PDF != nil
+}
+
+// pageCount is the number of pages of the document: one per .rm for a
+// notebook, the pages of the original for a PDF
+func (rmdoc *ReMarkableAPIrmdoc) pageCount() int {
+	if !rmdoc.isPDF() {
+		return len(rmdoc.Rmdata)
 	}
 
-	// Escribir metadatos de cuaderno
-	metadataFile, err := zipWriter.Create(notebookID + ".metadata")
-	if err != nil {
-		log.Fatalf("Error creating zip entry for metadata: %v", err)
+	count := CountPDFPages(rmdoc.Options.PDF)
+	if count == 0 {
+		slog.Debug("could not count the PDF pages, assuming one per annotation layer")
+		count = len(rmdoc.Rmdata)
 	}
-	_, err = metadataFile.Write(notebookMetadataData)
-	if err != nil {
-		log.Fatalf("Error writing metadata to zip entry: %v", err)
+	if len(rmdoc.Rmdata) > count {
+		slog.Debug("dropping annotation layers beyond the last PDF page", "layers", len(rmdoc.Rmdata)-count)
 	}
+	return count
+}
 
-	// Escribir archivos .rm
-	for i, pageID := range pageIDs {
-		rmFile, err := zipWriter.Create(notebookID + "/" + pageID + ".rm")
-		if err != nil {
-			log.Fatalf("Error creating zip entry for .rm file: %v", err)
-		}
-		_, err = rmFile.Write(rmdoc.Rmdata[i])
-		if err != nil {
-			log.Fatalf("Error writing .rm file to zip entry: %v", err)
-		}
-	}
+// hasAnnotations tells whether page i has a .rm layer to write
+func (rmdoc *ReMarkableAPIrmdoc) hasAnnotations(i int) bool {
+	return i < len(rmdoc.Rmdata) && len(rmdoc.Rmdata[i]) > 0
+}
 
-	rmdoc.internalBuffer = f
+// createEntry adds a compressed zip entry stamped with the document time
+func (rmdoc *ReMarkableAPIrmdoc) createEntry(zipWriter *zip.Writer, name string) (io.Writer, error) {
+	return zipWriter.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: time.Unix(rmdoc.Time, 0).UTC(),
+	})
 }
 
-func (rmdoc *ReMarkableAPIrmdoc) createContent(pageIDs []string) string {
+func (rmdoc *ReMarkableAPIrmdoc) createContent(pageIDs []string) (string, error) {
+	device := rmdoc.Options.Device.orDefault()
+
 	// Crear contenido JSON
 	content := struct {
 		CPages struct {
@@ -110,17 +262,7 @@ func (rmdoc *ReMarkableAPIrmdoc) createContent(pageIDs []string) string {
 				Timestamp string `json:"timestamp"`
 				Value     int    `json:"value"`
 			} `json:"original"`
-			Pages []struct {
-				ID  string `json:"id"`
-				Idx struct {
-					Timestamp string `json:"timestamp"`
-					Value     string `json:"value"`
-				} `json:"idx"`
-				Template struct {
-					Timestamp string `json:"timestamp"`
-					Value     string `json:"value"`
-				} `json:"template"`
-			} `json:"pages"`
+			Pages []contentPage `json:"pages"`
 			UUIDs []struct {
 				First  string `json:"first"`
 				Second int    `json:"second"`
@@ -144,21 +286,19 @@ func (rmdoc *ReMarkableAPIrmdoc) createContent(pageIDs []string) string {
 			LastPen              string `json:"LastPen"`
 			LastTool             string `json:"LastTool"`
 		} `json:"extraMetadata"`
-		FileType      string `json:"fileType"`
-		FontName      string `json:"fontName"`
-		FormatVersion int    `json:"formatVersion"`
-		LineHeight    int    `json:"lineHeight"`
-		Margins       int    `json:"margins"`
-		Orientation   string `json:"orientation"`
-		PageCount     int    `json:"pageCount"`
-		PageTags      []struct {
-		} `json:"pageTags"`
-		SizeInBytes string `json:"sizeInBytes"`
-		Tags        []struct {
-		} `json:"tags"`
-		TextAlignment string `json:"textAlignment"`
-		TextScale     int    `json:"textScale"`
-		ZoomMode      string `json:"zoomMode"`
+		FileType      string           `json:"fileType"`
+		FontName      string           `json:"fontName"`
+		FormatVersion int              `json:"formatVersion"`
+		LineHeight    int              `json:"lineHeight"`
+		Margins       int              `json:"margins"`
+		Orientation   string           `json:"orientation"`
+		PageCount     int              `json:"pageCount"`
+		PageTags      []contentPageTag `json:"pageTags"`
+		SizeInBytes   string           `json:"sizeInBytes"`
+		Tags          []contentTag     `json:"tags"`
+		TextAlignment string           `json:"textAlignment"`
+		TextScale     int              `json:"textScale"`
+		ZoomMode      string           `json:"zoomMode"`
 	}{
 		CPages: struct {
 			LastOpened struct {
@@ -169,17 +309,7 @@ func (rmdoc *ReMarkableAPIrmdoc) createContent(pageIDs []string) string {
 				Timestamp string `json:"timestamp"`
 				Value     int    `json:"value"`
 			} `json:"original"`
-			Pages []struct {
-				ID  string `json:"id"`
-				Idx struct {
-					Timestamp string `json:"timestamp"`
-					Value     string `json:"value"`
-				} `json:"idx"`
-				Template struct {
-					Timestamp string `json:"timestamp"`
-					Value     string `json:"value"`
-				} `json:"template"`
-			} `json:"pages"`
+			Pages []contentPage `json:"pages"`
 			UUIDs []struct {
 				First  string `json:"first"`
 				Second int    `json:"second"`
@@ -197,19 +327,9 @@ func (rmdoc *ReMarkableAPIrmdoc) createContent(pageIDs []string) string {
 				Value     int    `json:"value"`
 			}{
 				Timestamp: "1:1",
-				Value:     -1,
+				Value:     rmdoc.originalPageCount(len(pageIDs)),
 			},
-			Pages: make([]struct {
-				ID  string `json:"id"`
-				Idx struct {
-					Timestamp string `json:"timestamp"`
-					Value     string `json:"value"`
-				} `json:"idx"`
-				Template struct {
-					Timestamp string `json:"timestamp"`
-					Value     string `json:"value"`
-				} `json:"template"`
-			}, len(pageIDs)),
+			Pages: make([]contentPage, len(pageIDs)),
 			UUIDs: []struct {
 				First  string `json:"first"`
 				Second int    `json:"second"`
@@ -222,10 +342,10 @@ func (rmdoc *ReMarkableAPIrmdoc) createContent(pageIDs []string) string {
 		},
 		CoverPageNumber:       -1,
 		CustomZoomCenterX:     0,
-		CustomZoomCenterY:     936,
-		CustomZoomOrientation: "portrait",
-		CustomZoomPageHeight:  1872,
-		CustomZoomPageWidth:   1404,
+		CustomZoomCenterY:     int(device.Height / 2),
+		CustomZoomOrientation: rmdoc.orientation(),
+		CustomZoomPageHeight:  int(device.Height),
+		CustomZoomPageWidth:   int(device.Width),
 		CustomZoomScale:       1,
 		DocumentMetadata:      struct{}{},
 		ExtraMetadata: struct {
@@ -245,39 +365,29 @@ func (rmdoc *ReMarkableAPIrmdoc) createContent(pageIDs []string) string {
 			LastPen:              "Ballpointv2",
 			LastTool:             "Ballpointv2",
 		},
-		FileType:      "notebook",
+		FileType:      rmdoc.fileType(),
 		FontName:      "",
-		FormatVersion: 2,
+		FormatVersion: CONTENT_FORMAT_VERSION,
 		LineHeight:    -1,
 		Margins:       125,
-		Orientation:   "portrait",
+		Orientation:   rmdoc.orientation(),
 		PageCount:     len(pageIDs),
-		PageTags:      []struct{}{},
+		PageTags:      rmdoc.pageTags(pageIDs),
 		SizeInBytes: func(data [][]byte) string {
 			size := 0
 			for _, b := range data {
 				size += len(b)
 			}
-			return fmt.Sprint(size)
+			return fmt.Sprint(size + len(rmdoc.Options.PDF))
 		}(rmdoc.Rmdata),
-		Tags:          []struct{}{},
+		Tags:          rmdoc.docTags(),
 		TextAlignment: "justify",
 		TextScale:     1,
 		ZoomMode:      "bestFit",
 	}
 
 	for i, pageID := range pageIDs {
-		content.CPages.Pages[i] = struct {
-			ID  string `json:"id"`
-			Idx struct {
-				Timestamp string `json:"timestamp"`
-				Value     string `json:"value"`
-			} `json:"idx"`
-			Template struct {
-				Timestamp string `json:"timestamp"`
-				Value     string `json:"value"`
-			} `json:"template"`
-		}{
+		content.CPages.Pages[i] = contentPage{
 			ID: pageID,
 			Idx: struct {
 				Timestamp string `json:"timestamp"`
@@ -294,17 +404,26 @@ func (rmdoc *ReMarkableAPIrmdoc) createContent(pageIDs []string) string {
 				Value:     "Blank",
 			},
 		}
+		if rmdoc.isPDF() {
+			content.CPages.Pages[i].Redir = &struct {
+				Timestamp string `json:"timestamp"`
+				Value     int    `json:"value"`
+			}{
+				Timestamp: "1:2",
+				Value:     i,
+			}
+		}
 	}
 
 	contentJSON, err := json.MarshalIndent(content, "", "    ")
 	if err != nil {
-		log.Fatalf("Error marshaling content JSON: %v", err)
+		return "", fmt.Errorf("marshaling content JSON: %w", err)
 	}
 
-	return string(contentJSON)
+	return string(contentJSON), nil
 }
 
-func (rmdoc *ReMarkableAPIrmdoc) createNotebookMetadata(visibleName string) string {
+func (rmdoc *ReMarkableAPIrmdoc) createNotebookMetadata(visibleName string) (string, error) {
 	notebookMetadata := struct {
 		CreatedTime    int64  `json:"createdTime"`
 		LastModified   int64  `json:"lastModified"`
@@ -319,35 +438,115 @@ func (rmdoc *ReMarkableAPIrmdoc) createNotebookMetadata(visibleName string) stri
 		LastModified:   rmdoc.Time,
 		LastOpened:     rmdoc.Time,
 		LastOpenedPage: 0,
-		Parent:         "",
-		Pinned:         true,
+		Parent:         rmdoc.Options.Parent,
+		Pinned:         rmdoc.Options.Pinned,
 		Type:           "DocumentType",
 		VisibleName:    visibleName,
 	}
 
 	notebookMetadataJSON, err := json.MarshalIndent(notebookMetadata, "", "    ")
 	if err != nil {
-		log.Fatalf("Error marshaling notebook metadata JSON: %v", err)
+		return "", fmt.Errorf("marshaling notebook metadata JSON: %w", err)
 	}
 
-	return string(notebookMetadataJSON)
+	return string(notebookMetadataJSON), nil
 }
 
-func CreateRmDoc(rmName string, rmData [][]byte) (*bytes.Buffer, string) {
-	var zipName string
+func (rmdoc *ReMarkableAPIrmdoc) fileType() string {
+	if rmdoc.isPDF() {
+		return "pdf"
+	}
+	return "notebook"
+}
 
-	if strings.HasSuffix(rmName, ".rm") {
-		zipName = rmName[:len(rmName)-len(".rm")] + ".rmdoc"
-	} else {
-		zipName = rmName + ".rmdoc"
+func (rmdoc *ReMarkableAPIrmdoc) orientation() string {
+	if rmdoc.Options.Landscape {
+		return "landscape"
+	}
+	return "portrait"
+}
+
+// originalPageCount is -1 for notebooks, which have no original document
+func (rmdoc *ReMarkableAPIrmdoc) originalPageCount(pages int) int {
+	if rmdoc.isPDF() {
+		return pages
+	}
+	return -1
+}
+
+// contentPage is a page entry of the cPages list in the .content file
+type contentPage struct {
+	ID  string `json:"id"`
+	Idx struct {
+		Timestamp string `json:"timestamp"`
+		Value     string `json:"value"`
+	} `json:"idx"`
+	Redir *struct {
+		Timestamp string `json:"timestamp"`
+		Value     int    `json:"value"`
+	} `json:"redir,omitempty"`
+	Template struct {
+		Timestamp string `json:"timestamp"`
+		Value     string `json:"value"`
+	} `json:"template"`
+}
+
+// contentTag is a document tag as stored in the .content file
+type contentTag struct {
+	Name      string `json:"name"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// contentPageTag is a page tag as stored in the .content file
+type contentPageTag struct {
+	Name      string `json:"name"`
+	PageID    string `json:"pageId"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+func (rmdoc *ReMarkableAPIrmdoc) docTags() []contentTag {
+	tags := make([]contentTag, 0, len(rmdoc.Options.Tags))
+	for _, name := range rmdoc.Options.Tags {
+		tags = append(tags, contentTag{Name: name, Timestamp: rmdoc.Time * 1000})
+	}
+	return tags
+}
+
+func (rmdoc *ReMarkableAPIrmdoc) pageTags(pageIDs []string) []contentPageTag {
+	tags := make([]contentPageTag, 0)
+	for i, pageID := range pageIDs {
+		for _, name := range append(slices.Clip(rmdoc.Options.AllPageTags), rmdoc.Options.PageTags[i]...) {
+			tags = append(tags, contentPageTag{Name: name, PageID: pageID, Timestamp: rmdoc.Time * 1000})
+		}
 	}
+	return tags
+}
+
+func CreateRmDoc(rmName string, rmData [][]byte) (*bytes.Buffer, string, error) {
+	return CreateRmDocWithOptions(rmName, rmData, DefaultDocOptions())
+}
+
+// CreateRmDocWithOptions packs the pages into an .rmdoc filed according to opts
+func CreateRmDocWithOptions(rmName string, rmData [][]byte, opts DocOptions) (*bytes.Buffer, string, error) {
+	zipName := rmDocName(rmName)
 
-	rmdoc := NewReMarkableAPIrmdoc(zipName, rmData)
-	DebugPrint("File " + zipName + " created successfully.")
+	rmdoc, err := NewReMarkableAPIrmdocWithOptions(zipName, rmData, opts)
+	if err != nil {
+		return nil, zipName, fmt.Errorf("creating %s: %w", zipName, err)
+	}
+	slog.Debug("document created", "name", zipName)
 	rmdoc.Rmdata = nil
 	rmdoc.Content = ""
 	rmdoc.Metadata0rm = ""
 	rmdoc.NotebookMetadata = ""
 
-	return rmdoc.internalBuffer, zipName
+	return rmdoc.internalBuffer, zipName, nil
+}
+
+// rmDocName returns the .rmdoc file name for a page or document name
+func rmDocName(rmName string) string {
+	if strings.HasSuffix(rmName, ".rm") {
+		return rmName[:len(rmName)-len(".rm")] + ".rmdoc"
+	}
+	return rmName + ".rmdoc"
 }
@@ -0,0 +1,239 @@
+package remarkablepage
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const twoPagePDF = `%PDF-1.4
+1 0 obj << /Type /Catalog /Pages 2 0 R >> endobj
+2 0 obj << /Type /Pages /Kids [3 0 R 4 0 R] /Count 2 >> endobj
+3 0 obj << /Type /Page /Parent 2 0 R >> endobj
+4 0 obj << /Type /Page /Parent 2 0 R >> endobj
+%%EOF`
+
+// readRmDoc returns the entries of an .rmdoc keyed by extension, "content" and "metadata" included
+func readRmDoc(t *testing.T, data []byte) map[string][][]byte {
+	t.Helper()
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := make(map[string][][]byte)
+	for _, file := range reader.File {
+		rc, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(rc)
+		rc.Close()
+		ext := strings.TrimPrefix(filepath.Ext(file.Name), ".")
+		entries[ext] = append(entries[ext], body)
+	}
+	return entries
+}
+
+func TestDeterministicRmDoc(t *testing.T) {
+	page := NewReMarkablePage()
+	line := page.AddLine()
+	line.AddPoint(10, 10)
+	line.AddPoint(20, 10)
+	rmData := page.Export()
+
+	stamp := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	opts := DefaultDocOptions().Deterministic([]byte("input.png contents"), stamp)
+
+	first, firstName, err := CreateRmDocWithOptions("golden", [][]byte{rmData}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, secondName, _ := CreateRmDocWithOptions("golden", [][]byte{rmData}, opts)
+
+	if firstName != secondName {
+		t.Fatalf("names differ: %s != %s", firstName, secondName)
+	}
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Fatal("identical inputs produced different archives")
+	}
+
+	other, _, _ := CreateRmDocWithOptions("golden", [][]byte{rmData},
+		DefaultDocOptions().Deterministic([]byte("other contents"), stamp))
+	if bytes.Equal(first.Bytes(), other.Bytes()) {
+		t.Fatal("different inputs produced the same archive")
+	}
+}
+
+func TestPDFRmDoc(t *testing.T) {
+	if n := CountPDFPages([]byte(twoPagePDF)); n != 2 {
+		t.Fatalf("expected 2 pages, got %d", n)
+	}
+
+	opts := DefaultDocOptions()
+	opts.PDF = []byte(twoPagePDF)
+	rmdoc, _, _ := CreateRmDocWithOptions("overlay", [][]byte{NewReMarkablePage().Export()}, opts)
+
+	entries := readRmDoc(t, rmdoc.Bytes())
+	if len(entries["pdf"]) != 1 || len(entries["rm"]) != 1 {
+		t.Fatalf("expected one pdf and one annotation layer, got %d and %d", len(entries["pdf"]), len(entries["rm"]))
+	}
+
+	var content struct {
+		FileType  string `json:"fileType"`
+		PageCount int    `json:"pageCount"`
+		CPages    struct {
+			Pages []struct {
+				Redir struct {
+					Value int `json:"value"`
+				} `json:"redir"`
+			} `json:"pages"`
+		} `json:"cPages"`
+	}
+	if err := json.Unmarshal(entries["content"][0], &content); err != nil {
+		t.Fatal(err)
+	}
+	if content.FileType != "pdf" || content.PageCount != 2 || content.CPages.Pages[1].Redir.Value != 1 {
+		t.Fatalf("unexpected content: %+v", content)
+	}
+}
+
+func TestExportXochitlDir(t *testing.T) {
+	dir := t.TempDir()
+
+	id, err := ExportXochitlDir(dir, "exported", [][]byte{NewReMarkablePage().Export()}, DefaultDocOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pattern := range []string{id + ".metadata", id + ".content", id + "/*.rm", id + "/*-metadata.json"} {
+		matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+		if len(matches) != 1 {
+			t.Errorf("expected one %s, found %d", pattern, len(matches))
+		}
+	}
+}
+
+func TestLandscapeRmDoc(t *testing.T) {
+	opts := DefaultDocOptions()
+	opts.Landscape = true
+	data, _, _ := CreateRmDocWithOptions("wide", [][]byte{NewReMarkablePage().Export()}, opts)
+
+	var content struct {
+		Orientation           string `json:"orientation"`
+		CustomZoomOrientation string `json:"customZoomOrientation"`
+	}
+	if err := json.Unmarshal(readRmDoc(t, data.Bytes())["content"][0], &content); err != nil {
+		t.Fatal(err)
+	}
+	if content.Orientation != "landscape" || content.CustomZoomOrientation != "landscape" {
+		t.Errorf("orientation %q, zoom orientation %q", content.Orientation, content.CustomZoomOrientation)
+	}
+
+	info, err := ReadRmDoc(data.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Orientation != "landscape" {
+		t.Errorf("read back as %q", info.Orientation)
+	}
+}
+
+func TestDeviceRmDoc(t *testing.T) {
+	opts := DefaultDocOptions()
+	opts.Device = DEVICE_PAPER_PRO
+	data, _, _ := CreateRmDocWithOptions("pro", [][]byte{NewReMarkablePage().Export()}, opts)
+
+	var content struct {
+		CustomZoomCenterY    int `json:"customZoomCenterY"`
+		CustomZoomPageWidth  int `json:"customZoomPageWidth"`
+		CustomZoomPageHeight int `json:"customZoomPageHeight"`
+		FormatVersion        int `json:"formatVersion"`
+	}
+	if err := json.Unmarshal(readRmDoc(t, data.Bytes())["content"][0], &content); err != nil {
+		t.Fatal(err)
+	}
+	if content.CustomZoomPageWidth != 1620 || content.CustomZoomPageHeight != 2160 || content.CustomZoomCenterY != 1080 {
+		t.Errorf("page %dx%d centred on %d, want the Paper Pro canvas",
+			content.CustomZoomPageWidth, content.CustomZoomPageHeight, content.CustomZoomCenterY)
+	}
+	if content.FormatVersion != CONTENT_FORMAT_VERSION {
+		t.Errorf("format version %d", content.FormatVersion)
+	}
+
+	info, err := ReadRmDoc(data.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.PageWidth != 1620 || info.PageHeight != 2160 {
+		t.Errorf("read back as %dx%d", info.PageWidth, info.PageHeight)
+	}
+}
+
+func TestAllPageTags(t *testing.T) {
+	opts := DefaultDocOptions()
+	opts.AllPageTags = []string{"scan"}
+	opts.PageTags = map[int][]string{1: {"cover"}}
+	page := NewReMarkablePage().Export()
+	data, _, _ := CreateRmDocWithOptions("tagged", [][]byte{page, page, page}, opts)
+
+	var content struct {
+		PageTags []contentPageTag `json:"pageTags"`
+	}
+	if err := json.Unmarshal(readRmDoc(t, data.Bytes())["content"][0], &content); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, tag := range content.PageTags {
+		names = append(names, tag.Name)
+	}
+	if want := "scan scan cover scan"; strings.Join(names, " ") != want {
+		t.Errorf("page tags %q, want %q", names, want)
+	}
+}
+
+func TestSeededIDsConcurrent(t *testing.T) {
+	next := SeededIDs([]byte("seed"))
+	ids := make(chan string, 100)
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ids <- next()
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[string]bool)
+	for id := range ids {
+		seen[id] = true
+	}
+	if len(seen) != 100 {
+		t.Errorf("%d distinct IDs out of 100", len(seen))
+	}
+}
+
+func TestExtractRmDocRejectsUnsafeNames(t *testing.T) {
+	for _, name := range []string{"../evil.metadata", "/etc/evil.metadata", "doc/../../evil.metadata"} {
+		var buf bytes.Buffer
+		writer := zip.NewWriter(&buf)
+		entry, _ := writer.Create(name)
+		entry.Write([]byte("{}"))
+		writer.Close()
+
+		dir := t.TempDir()
+		if _, err := ExtractRmDoc(filepath.Join(dir, "library"), buf.Bytes()); err == nil {
+			t.Errorf("%s extracted", name)
+		}
+		if matches, _ := filepath.Glob(filepath.Join(dir, "*")); len(matches) > 0 {
+			t.Errorf("%s wrote %v", name, matches)
+		}
+	}
+}
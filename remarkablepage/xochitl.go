@@ -0,0 +1,123 @@
+package remarkablepage
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// pageMetadata is the <page>-metadata.json written next to every .rm file
+const pageMetadata = `{
+    "layers": [
+        {
+            "name": "Layer 1"
+        }
+    ]
+}
+`
+
+// ExportXochitlDir writes the pages as a document in the layout xochitl uses
+// for its own storage: <id>.metadata, <id>.content, <id>/<page>.rm and
+// <id>/<page>-metadata.json. The files are written under temporary names and
+// renamed in place, so a watcher never sees a half written document. It
+// returns the ID of the new document.
+func ExportXochitlDir(dir, rmName string, rmData [][]byte, opts DocOptions) (string, error) {
+	rmdoc, err := newRmDoc(rmDocName(rmName), rmData, opts)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, rmdoc.notebookID), 0755); err != nil {
+		return "", fmt.Errorf("creating document directory: %w", err)
+	}
+
+	if err := writeXochitlEntries(dir, rmdoc.notebookID, rmdoc.entries(rmdoc.notebookID, rmdoc.pageIDs)); err != nil {
+		return "", err
+	}
+
+	slog.Debug("document exported", "id", rmdoc.notebookID, "dir", dir)
+	return rmdoc.notebookID, nil
+}
+
+// ExtractRmDoc unpacks an .rmdoc archive into dir in the xochitl storage
+// layout, the same way ExportXochitlDir writes it, and returns the document ID
+func ExtractRmDoc(dir string, rmdoc []byte) (string, error) {
+	reader, err := zip.NewReader(bytes.NewReader(rmdoc), int64(len(rmdoc)))
+	if err != nil {
+		return "", fmt.Errorf("not an .rmdoc archive: %w", err)
+	}
+
+	var notebookID string
+	var entries []docEntry
+	for _, file := range reader.File {
+		name := path.Clean(file.Name)
+		if !filepath.IsLocal(filepath.FromSlash(name)) {
+			return "", fmt.Errorf("unsafe entry %s in archive", file.Name)
+		}
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		data, err := readZipEntry(file)
+		if err != nil {
+			return "", err
+		}
+		if path.Ext(name) == ".metadata" && !strings.Contains(name, "/") {
+			notebookID = strings.TrimSuffix(name, ".metadata")
+		}
+		entries = append(entries, docEntry{name: name, data: data})
+	}
+	if notebookID == "" {
+		return "", fmt.Errorf("archive has no .metadata")
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, notebookID), 0755); err != nil {
+		return "", fmt.Errorf("creating document directory: %w", err)
+	}
+	if err := writeXochitlEntries(dir, notebookID, entries); err != nil {
+		return "", err
+	}
+
+	slog.Debug("document extracted", "id", notebookID, "dir", dir)
+	return notebookID, nil
+}
+
+// writeXochitlEntries writes the document files, adding the per page
+// metadata xochitl expects next to every .rm, and the .metadata last since
+// xochitl lists a document as soon as it appears
+func writeXochitlEntries(dir, notebookID string, entries []docEntry) error {
+	var metadata docEntry
+	for _, entry := range entries {
+		if entry.name == notebookID+".metadata" {
+			metadata = entry
+			continue
+		}
+		if err := writeFileAtomic(filepath.Join(dir, entry.name), entry.data); err != nil {
+			return err
+		}
+		if path.Ext(entry.name) == ".rm" {
+			pageMeta := strings.TrimSuffix(entry.name, ".rm") + "-metadata.json"
+			if err := writeFileAtomic(filepath.Join(dir, pageMeta), []byte(pageMetadata)); err != nil {
+				return err
+			}
+		}
+	}
+	return writeFileAtomic(filepath.Join(dir, metadata.name), metadata.data)
+}
+
+// writeFileAtomic writes data to a temporary file and renames it to path
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", filepath.Base(path), err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("writing %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
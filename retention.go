@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"syscall"
+	"time"
+
+	fp "path/filepath"
+)
+
+// Values of the retention setting, what happens to a screenshot once its
+// document has been delivered. Screenshots that could not be delivered are
+// always left in place.
+const (
+	RETENTION_DELETE    = "delete"    // Delete it
+	RETENTION_ARCHIVE   = "archive"   // Move it to archive_dir/YYYY/MM/DD
+	RETENTION_KEEP_LAST = "keep_last" // Leave it, deleting the delivered ones past the keep_last newest of its directory
+	RETENTION_KEEP      = "keep"      // Leave it
+)
+
+// ARCHIVE_LAYOUT lays the archive out by delivery date
+const ARCHIVE_LAYOUT = "2006/01/02"
+
+// retain applies the retention policy of the config to a delivered screenshot
+func retain(config *Config, source string) error {
+	switch config.Retention {
+	case RETENTION_ARCHIVE:
+		_, err := archiveFile(config.ArchiveDir, source, time.Now())
+		return err
+	case RETENTION_KEEP_LAST:
+		return pruneDelivered(config, fp.Dir(source), config.KeepLast)
+	case RETENTION_KEEP:
+		return nil
+	default:
+		return deleteFile(source)
+	}
+}
+
+// archiveFile moves path into the dated subdirectory of dir, numbering the
+// name when the archive already has a file by that name, and returns the new path
+func archiveFile(dir, path string, now time.Time) (string, error) {
+	target := fp.Join(dir, fp.FromSlash(now.Format(ARCHIVE_LAYOUT)))
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return "", err
+	}
+
+	name := fp.Base(path)
+	dest := fp.Join(target, name)
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(dest); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		dest = fp.Join(target, fmt.Sprintf("%s-%d%s", trimExt(name), i, fp.Ext(name)))
+	}
+	return dest, moveFile(path, dest)
+}
+
+// moveFile renames src to dest, copying it when they are on different filesystems
+func moveFile(src, dest string) error {
+	err := os.Rename(src, dest)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dest)
+		return err
+	}
+	return os.Remove(src)
+}
+
+// pruneDelivered deletes the screenshots of dir the ledger lists as
+// delivered, except for the keep most recently modified ones. Screenshots
+// not delivered yet and files no rule matches are never touched. Files are
+// taken newest first, so only the ones the ledger does not know by path and
+// modification time are hashed.
+func pruneDelivered(config *Config, dir string, keep int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	type candidate struct {
+		path string
+		info fs.FileInfo
+	}
+	var files []candidate
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		path := fp.Join(dir, entry.Name())
+		if config.ruleFor(path) == nil {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			files = append(files, candidate{path, info})
+		}
+	}
+	if len(files) <= keep {
+		return nil
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].info.ModTime().After(files[j].info.ModTime()) })
+	var errs []error
+	kept := 0
+	for _, file := range files {
+		if !ledger.Delivered(file.path, file.info) {
+			continue
+		}
+		if kept < keep {
+			kept++
+			continue
+		}
+		if err := deleteFile(file.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	fp "path/filepath"
+
+	rp "github.com/pragmatically-dev/PoC-drawj2d-port-go/remarkablepage"
+)
+
+func TestArchiveFileUsesDateLayout(t *testing.T) {
+	dir := t.TempDir()
+	archive := fp.Join(dir, "archive")
+	now := time.Date(2024, 3, 9, 12, 0, 0, 0, time.UTC)
+
+	var archived []string
+	for i := 0; i < 2; i++ {
+		source := fp.Join(dir, "Screenshot.png")
+		os.WriteFile(source, []byte("png"), 0644)
+		dest, err := archiveFile(archive, source, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(source); !os.IsNotExist(err) {
+			t.Error("source left in place")
+		}
+		archived = append(archived, dest)
+	}
+
+	want := []string{
+		fp.Join(archive, "2024", "03", "09", "Screenshot.png"),
+		fp.Join(archive, "2024", "03", "09", "Screenshot-1.png"),
+	}
+	for i := range want {
+		if archived[i] != want[i] {
+			t.Errorf("archived to %s, want %s", archived[i], want[i])
+		}
+		if _, err := os.Stat(want[i]); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestKeepLastOnlyPrunesDeliveredScreenshots(t *testing.T) {
+	var err error
+	ledger, err = OpenLedger(fp.Join(t.TempDir(), "processed.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ledger = nil })
+
+	config := testQueueConfig()
+	config.DirToSearch = t.TempDir()
+	config.Retention = RETENTION_KEEP_LAST
+	config.KeepLast = 2
+	if err := config.prepare(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Four delivered screenshots, oldest first, and one still pending
+	var delivered []string
+	for i, name := range []string{"Screenshot_1.png", "Screenshot_2.png", "Screenshot_3.png", "Screenshot_4.png"} {
+		path := fp.Join(config.DirToSearch, name)
+		os.WriteFile(path, []byte(name), 0644)
+		modTime := time.Now().Add(time.Duration(i-10) * time.Minute)
+		os.Chtimes(path, modTime, modTime)
+		ledger.Record(path)
+		delivered = append(delivered, path)
+	}
+	pending := fp.Join(config.DirToSearch, "Screenshot_0.png")
+	os.WriteFile(pending, []byte("pending"), 0644)
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(pending, old, old)
+
+	if err := retain(config, delivered[3]); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, path := range append(delivered, pending) {
+		_, err := os.Stat(path)
+		if kept := err == nil; kept != (i >= 2) {
+			t.Errorf("%s kept = %v", fp.Base(path), kept)
+		}
+	}
+}
+
+func TestRetentionLeavesFailedPages(t *testing.T) {
+	failing := func(path string, mode rp.Mode, layout rp.Layout) ([][]byte, rp.ConversionStats, error) {
+		if strings.Contains(path, "Broken") {
+			return nil, rp.ConversionStats{}, errors.New("unreadable")
+		}
+		return emptyPage(path, mode, layout)
+	}
+	config := testQueueConfig()
+	config.Retention = RETENTION_ARCHIVE
+	config.ArchiveDir = t.TempDir()
+	config, rule := startProcessing(t, config, failing, func(string, []byte, string) error { return nil })
+
+	broken := writeScreenshot(t, config.DirToSearch, "Broken.png")
+	good := writeScreenshot(t, config.DirToSearch, "Screenshot.png")
+	job := waitForJob(t, processing.Submit(config, rule, []string{good, broken}))
+	if job.State != JOB_DONE {
+		t.Fatalf("job = %+v", job)
+	}
+
+	if _, err := os.Stat(broken); err != nil {
+		t.Errorf("page that failed to convert was retained: %v", err)
+	}
+	archived, _ := fp.Glob(fp.Join(config.ArchiveDir, "*", "*", "*", "*"))
+	if len(archived) != 1 || fp.Base(archived[0]) != "Screenshot.png" {
+		t.Errorf("archived %v, want only Screenshot.png", archived)
+	}
+}
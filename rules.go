@@ -0,0 +1,246 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	fp "path/filepath"
+
+	rp "github.com/pragmatically-dev/PoC-drawj2d-port-go/remarkablepage"
+)
+
+// WatchRule selects screenshots by directory and file name and says how they
+// are converted and where they are delivered. Empty settings fall back to the
+// top level ones.
+type WatchRule struct {
+	Name         string      `yaml:"name"`
+	Dir          string      `yaml:"dir"`
+	Patterns     []string    `yaml:"patterns"`  // Globs on the file name, or regular expressions prefixed with "re:"
+	Recursive    bool        `yaml:"recursive"` // Also watch the subdirectories of Dir
+	Mode         string      `yaml:"mode"`      // Pipeline mode, edges when empty
+	Folder       string      `yaml:"folder"`
+	NameTemplate string      `yaml:"name_template"`
+	Sink         *SinkConfig `yaml:"sink"`
+
+	matchers []func(name string) bool
+	mode     rp.Mode
+	docOpts  rp.DocOptions
+	namer    *Namer
+	sink     SinkConfig
+}
+
+// watchRules returns the configured rules, or when there are none the single
+// rule made of dir_to_search and file_prefix
+func (config *Config) watchRules() []WatchRule {
+	if len(config.Rules) > 0 {
+		return config.Rules
+	}
+	return []WatchRule{{
+		Name:     "default",
+		Dir:      config.DirToSearch,
+		Patterns: []string{escapeGlob(config.FilePrefix) + "*"},
+	}}
+}
+
+// escapeGlob quotes the glob metacharacters of s
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// compilePattern returns the matcher of a glob or "re:" pattern
+func compilePattern(pattern string) (func(name string) bool, error) {
+	if expr, ok := strings.CutPrefix(pattern, "re:"); ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString, nil
+	}
+	if _, err := fp.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("%q: %w", pattern, err)
+	}
+	return func(name string) bool {
+		matched, _ := fp.Match(pattern, name)
+		return matched
+	}, nil
+}
+
+// validate checks the rule settings that do not need the filesystem
+func (rule *WatchRule) validate(key string) []error {
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: "+format, append([]any{key}, args...)...))
+	}
+
+	if rule.Dir == "" {
+		invalid("dir must not be empty")
+	}
+	if len(rule.Patterns) == 0 {
+		invalid("patterns must not be empty")
+	}
+	for _, pattern := range rule.Patterns {
+		if _, err := compilePattern(pattern); err != nil {
+			invalid("pattern %v", err)
+		}
+	}
+	if rule.Mode != "" {
+		if _, err := rp.ParseMode(rule.Mode); err != nil {
+			invalid("%v", err)
+		}
+	}
+	if rule.NameTemplate != "" {
+		if _, err := NewNamer(rule.NameTemplate); err != nil {
+			invalid("name_template: %v", err)
+		}
+	}
+	if rule.Sink != nil {
+		errs = append(errs, rule.Sink.validate(key+".sink")...)
+	}
+	return errs
+}
+
+// prepare resolves the rule against the top level settings of the config
+func (rule *WatchRule) prepare(config *Config) error {
+	rule.matchers = nil
+	for _, pattern := range rule.Patterns {
+		matcher, err := compilePattern(pattern)
+		if err != nil {
+			return err
+		}
+		rule.matchers = append(rule.matchers, matcher)
+	}
+
+	rule.mode = rp.MODE_EDGES
+	if rule.Mode != "" {
+		mode, err := rp.ParseMode(rule.Mode)
+		if err != nil {
+			return err
+		}
+		rule.mode = mode
+	}
+
+	rule.docOpts = config.docOpts
+	if rule.Folder != "" {
+		parent, err := config.lookupFolder(rule.Folder)
+		if err != nil {
+			return fmt.Errorf("rule %s: %w", rule.Name, err)
+		}
+		rule.docOpts.Parent = parent
+	}
+
+	// Rules without their own template share the numbering of the top level one
+	rule.namer = config.namer
+	if rule.NameTemplate != "" {
+		namer, err := NewNamer(rule.NameTemplate)
+		if err != nil {
+			return err
+		}
+		rule.namer = namer
+	}
+
+	rule.sink = config.Sink
+	if rule.Sink != nil {
+		rule.sink = *rule.Sink
+	}
+	return nil
+}
+
+// validateDir checks that the watched directory exists
+func (rule *WatchRule) validateDir(key string) error {
+	info, err := os.Stat(rule.Dir)
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s: %s is not a directory", key, rule.Dir)
+	}
+	return nil
+}
+
+// matches tells whether the file at path falls under the rule
+func (rule *WatchRule) matches(path string) bool {
+	dir := fp.Clean(fp.Dir(path))
+	root := fp.Clean(rule.Dir)
+	if dir != root {
+		rel, err := fp.Rel(root, dir)
+		if !rule.Recursive || err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(fp.Separator)) {
+			return false
+		}
+	}
+
+	name := fp.Base(path)
+	for _, matcher := range rule.matchers {
+		if matcher(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// ruleFor returns the first rule the file falls under, or nil
+func (config *Config) ruleFor(path string) *WatchRule {
+	for _, rule := range config.rules {
+		if rule.matches(path) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// watchedDirs lists every directory the rules need watched, walking the
+// recursive ones
+func (config *Config) watchedDirs() ([]string, error) {
+	seen := make(map[string]bool)
+	var dirs []string
+	add := func(dir string) {
+		if dir = fp.Clean(dir); !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+
+	var errs []error
+	for _, rule := range config.rules {
+		if !rule.Recursive {
+			add(rule.Dir)
+			continue
+		}
+		err := fp.WalkDir(rule.Dir, func(path string, entry os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() {
+				add(path)
+			}
+			return nil
+		})
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return dirs, errors.Join(errs...)
+}
+
+// watchesRecursively tells whether new subdirectories of dir must be watched
+func (config *Config) watchesRecursively(dir string) bool {
+	for _, rule := range config.rules {
+		if !rule.Recursive {
+			continue
+		}
+		rel, err := fp.Rel(fp.Clean(rule.Dir), fp.Clean(dir))
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(fp.Separator)) {
+			return true
+		}
+	}
+	return false
+}
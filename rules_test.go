@@ -0,0 +1,173 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	fp "path/filepath"
+
+	rp "github.com/pragmatically-dev/PoC-drawj2d-port-go/remarkablepage"
+)
+
+func TestWatchRulesMatchByDirectoryAndPattern(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(fp.Join(dir, "photos", "2026"), 0755)
+
+	path := fp.Join(dir, "config.yaml")
+	os.WriteFile(path, []byte(`
+rules:
+  - name: notes
+    dir: `+dir+`
+    patterns: ["Screenshot*"]
+  - name: photos
+    dir: `+dir+`
+    patterns: ["Photo*", "re:^IMG_[0-9]+\\.jpe?g$"]
+    recursive: true
+    mode: photo
+    sink:
+      type: dir
+      path: `+dir+`
+`), 0644)
+
+	config, err := LoadConfig(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.validateWatch(); err != nil {
+		t.Fatal(err)
+	}
+
+	for file, want := range map[string]string{
+		"Screenshot_1.png":              "notes",
+		"Photo_1.png":                   "photos",
+		"photos/2026/IMG_0042.jpg":      "photos",
+		"photos/Screenshot_2.png":       "",
+		"Notes.png":                     "",
+		"../elsewhere/Screenshot_3.png": "",
+	} {
+		rule, got := config.ruleFor(fp.Join(dir, file)), ""
+		if rule != nil {
+			got = rule.Name
+		}
+		if got != want {
+			t.Errorf("%s: got rule %q, want %q", file, got, want)
+		}
+	}
+
+	photos := config.ruleFor(fp.Join(dir, "Photo_1.png"))
+	if photos.mode != rp.MODE_PHOTO || photos.sink.Type != SINK_DIR {
+		t.Errorf("photos rule not resolved: mode %s, sink %s", photos.mode, photos.sink.Type)
+	}
+	if notes := config.ruleFor(fp.Join(dir, "Screenshot_1.png")); notes.mode != rp.MODE_EDGES || notes.sink.Type != SINK_WEB {
+		t.Errorf("notes rule does not fall back to the defaults: mode %s, sink %s", notes.mode, notes.sink.Type)
+	}
+
+	dirs, err := config.watchedDirs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) != 3 {
+		t.Errorf("watched dirs = %v, want the root and both photo subdirectories", dirs)
+	}
+}
+
+func TestDefaultRuleFromPrefix(t *testing.T) {
+	config := defaultConfig()
+	config.DirToSearch, config.FilePrefix = t.TempDir(), "Shot[1]"
+	if err := config.prepare(); err != nil {
+		t.Fatal(err)
+	}
+
+	if config.ruleFor(fp.Join(config.DirToSearch, "Shot[1] copy.png")) == nil {
+		t.Error("the prefix is not matched literally")
+	}
+	if config.ruleFor(fp.Join(config.DirToSearch, "Shot1.png")) != nil {
+		t.Error("the prefix is matched as a glob")
+	}
+}
+
+func TestInvalidWatchRules(t *testing.T) {
+	path := fp.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte(`
+rules:
+  - dir: ""
+    patterns: ["re:("]
+    mode: sketch
+`), 0644)
+
+	_, err := LoadConfig(path, nil)
+	if err == nil {
+		t.Fatal("expected validation errors")
+	}
+	for _, want := range []string{"dir must not be empty", "pattern", "sketch"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %s: %v", want, err)
+		}
+	}
+}
+
+func TestFolderRejectedForSSHSink(t *testing.T) {
+	dir := t.TempDir()
+	path := fp.Join(dir, "config.yaml")
+	os.WriteFile(path, []byte(`
+xochitl_dir: `+dir+`
+rules:
+  - dir: `+dir+`
+    patterns: ["Screenshot*"]
+    folder: Notes
+    sink:
+      type: ssh
+      host: root@10.11.99.1
+      path: /home/root/.local/share/remarkable/xochitl
+`), 0644)
+
+	_, err := LoadConfig(path, nil)
+	if err == nil || !strings.Contains(err.Error(), "rules[0].folder: cannot be used with the ssh sink") {
+		t.Fatalf("expected the folder to be rejected, got %v", err)
+	}
+}
+
+func TestCreateFoldersRestartsXochitlOnce(t *testing.T) {
+	restarts := 0
+	xochitl.restart = func() error {
+		restarts++
+		return nil
+	}
+	t.Cleanup(func() { xochitl.restart = nil })
+
+	config := defaultConfig()
+	config.XochitlDir = t.TempDir()
+	config.Folder = "Work/Notes"
+	config.PageTags = []string{"scan"}
+	config.Rules = []WatchRule{{Name: "photos", Dir: t.TempDir(), Folder: "Work/Photos"}}
+
+	// Loading the config changes nothing on the device
+	if err := config.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(config.XochitlDir); len(entries) != 0 || restarts != 0 {
+		t.Fatalf("prepare created %d files and restarted xochitl %d times", len(entries), restarts)
+	}
+	if config.docOpts.Parent != "" || !config.missingFolders {
+		t.Fatalf("missing folder resolved to %q", config.docOpts.Parent)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := config.createFolders(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if restarts != 1 {
+		t.Errorf("%d restarts, want one for the folders created", restarts)
+	}
+	if config.docOpts.Parent == "" || config.rules[0].docOpts.Parent == "" || config.rules[0].docOpts.Parent == config.docOpts.Parent {
+		t.Errorf("parents %q and %q after creating the folders", config.docOpts.Parent, config.rules[0].docOpts.Parent)
+	}
+	if tags := config.docOpts.AllPageTags; len(tags) != 1 || tags[0] != "scan" {
+		t.Errorf("page tags %v", tags)
+	}
+}
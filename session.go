@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Values of the multiple_mode setting
+const (
+	MULTIPLE_MODE_AUTO = "auto" // Follow the multiple_mode_key of xochitl.conf
+	MULTIPLE_MODE_ON   = "on"
+	MULTIPLE_MODE_OFF  = "off"
+)
+
+// readIni parses an INI file such as xochitl.conf into section -> key -> value.
+// Keys before the first section header go in the "" section.
+func readIni(path string) (map[string]map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	ini := map[string]map[string]string{"": {}}
+	section := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if ini[section] == nil {
+				ini[section] = map[string]string{}
+			}
+		default:
+			key, value, ok := strings.Cut(line, "=")
+			if !ok {
+				continue
+			}
+			value = strings.TrimSpace(value)
+			if unquoted, err := strconv.Unquote(value); err == nil {
+				value = unquoted
+			}
+			ini[section][strings.TrimSpace(key)] = value
+		}
+	}
+	return ini, scanner.Err()
+}
+
+// isMultipleModeActive tells whether screenshots are currently batched
+func isMultipleModeActive(config *Config) bool {
+	switch config.MultipleMode {
+	case MULTIPLE_MODE_ON:
+		return true
+	case MULTIPLE_MODE_OFF:
+		return false
+	}
+
+	ini, err := readIni(config.XochitlConf)
+	if err != nil {
+		slog.Debug("cannot read the xochitl config", "path", config.XochitlConf, "err", err)
+		return false
+	}
+
+	// The key is either "Section/Key" or a bare key looked up in every section
+	section, key, found := strings.Cut(config.MultipleModeKey, "/")
+	if !found {
+		section, key = "", config.MultipleModeKey
+		for name, values := range ini {
+			if _, ok := values[key]; ok {
+				section = name
+				break
+			}
+		}
+	}
+
+	active, err := strconv.ParseBool(strings.ToLower(ini[section][key]))
+	return err == nil && active
+}
+
+// multiSession collects the screenshots taken while multiple mode is on, to
+// be converted into a single notebook when the session closes
+type multiSession struct {
+	rule     *WatchRule // Rule of the screenshots, sessions never mix rules
+	sources  []string
+	deadline *time.Timer
+}
+
+// add appends the screenshot and restarts the idle timeout
+func (session *multiSession) add(filepath string, rule *WatchRule, timeout time.Duration) {
+	session.rule = rule
+	session.sources = append(session.sources, filepath)
+	watching.sessionPages.Store(int64(len(session.sources)))
+
+	if session.deadline == nil {
+		session.deadline = time.NewTimer(timeout)
+		return
+	}
+	if !session.deadline.Stop() {
+		select {
+		case <-session.deadline.C:
+		default:
+		}
+	}
+	session.deadline.Reset(timeout)
+}
+
+// expired fires once the session has been idle for the timeout; it never
+// fires while no session is open
+func (session *multiSession) expired() <-chan time.Time {
+	if session.deadline == nil {
+		return nil
+	}
+	return session.deadline.C
+}
+
+func (session *multiSession) isOpen() bool {
+	return len(session.sources) > 0
+}
+
+// close submits the collected screenshots as one notebook named after the
+// first one and starts a new empty session
+func (session *multiSession) close(config *Config, reason string) {
+	if !session.isOpen() {
+		return
+	}
+	if session.deadline != nil {
+		session.deadline.Stop()
+	}
+	rule, sources := session.rule, session.sources
+	*session = multiSession{}
+	watching.sessionPages.Store(0)
+
+	slog.Info("closing multiple mode session", "reason", reason, "pages", len(sources))
+	processing.Submit(config, rule, sources)
+}
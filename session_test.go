@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	fp "path/filepath"
+
+	rp "github.com/pragmatically-dev/PoC-drawj2d-port-go/remarkablepage"
+)
+
+func TestIsMultipleModeActive(t *testing.T) {
+	conf := fp.Join(t.TempDir(), "xochitl.conf")
+	os.WriteFile(conf, []byte(`[General]
+; comment
+DeveloperMode = false
+drawj2dMultipleMode = true
+
+[Plugins]
+otherKey="yes"
+`), 0644)
+
+	config := defaultConfig()
+	config.XochitlConf = conf
+	config.MultipleMode = MULTIPLE_MODE_AUTO
+	config.MultipleModeKey = "General/drawj2dMultipleMode"
+
+	if !isMultipleModeActive(config) {
+		t.Fatal("expected multiple mode to follow General/drawj2dMultipleMode")
+	}
+
+	config.MultipleModeKey = "DeveloperMode"
+	if isMultipleModeActive(config) {
+		t.Fatal("expected a bare key to be found in its section")
+	}
+
+	config.MultipleModeKey = "Plugins/otherKey"
+	if isMultipleModeActive(config) {
+		t.Fatal("\"yes\" is not a boolean")
+	}
+
+	config.MultipleMode = MULTIPLE_MODE_ON
+	if !isMultipleModeActive(config) {
+		t.Fatal("expected multiple mode forced on")
+	}
+}
+
+func TestMultipleModeSessionMakesOneNotebook(t *testing.T) {
+	config := testQueueConfig()
+	config.MultipleMode = MULTIPLE_MODE_ON
+	config.SessionTimeout = time.Minute
+
+	var mu sync.Mutex
+	var delivered [][]byte
+	config, _ = startProcessing(t, config, emptyPage, func(name string, rmdoc []byte, parent string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		delivered = append(delivered, rmdoc)
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	trigger, ready, stopped := make(chan os.Signal, 1), make(chan struct{}), make(chan struct{})
+	go func() {
+		watchForScreenshots(ctx, config, watchSignals{trigger: trigger, ready: ready})
+		close(stopped)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	// Screenshots already there when the watcher starts are picked up one by one
+	<-ready
+	writeScreenshot(t, config.DirToSearch, "Screenshot_1.png")
+	writeScreenshot(t, config.DirToSearch, "Screenshot_2.png")
+	waitFor(t, func() bool { return watching.sessionPages.Load() == 2 })
+	trigger <- syscall.SIGUSR1
+
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(delivered) > 0
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(delivered) != 1 {
+		t.Fatalf("%d documents delivered, want one for the session", len(delivered))
+	}
+	info, err := rp.ReadRmDoc(delivered[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.PageIDs) != 2 {
+		t.Errorf("%d pages, want both screenshots", len(info.PageIDs))
+	}
+}
+
+// waitFor polls done until it holds, failing the test after a few seconds
+func waitFor(t *testing.T, done func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(3 * time.Second); !done(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+	}
+}
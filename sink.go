@@ -0,0 +1,310 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	fp "path/filepath"
+
+	rp "github.com/pragmatically-dev/PoC-drawj2d-port-go/remarkablepage"
+)
+
+// Sink types, selected by sink.type
+const (
+	SINK_WEB     = "web"     // POST to the USB web interface
+	SINK_DIR     = "dir"     // Write into a local directory
+	SINK_XOCHITL = "xochitl" // Write into the xochitl storage directory of this device
+	SINK_SSH     = "ssh"     // Copy to a remote path with scp
+)
+
+// Layouts of the documents written by the dir and ssh sinks
+const (
+	LAYOUT_RMDOC   = "rmdoc"   // One .rmdoc archive per document
+	LAYOUT_XOCHITL = "xochitl" // Loose files as in the xochitl storage directory
+)
+
+// How xochitl is made to notice documents written into its directory
+const (
+	XOCHITL_RELOAD_RESTART = "restart" // Restart the xochitl service once writes settle
+	XOCHITL_RELOAD_NONE    = "none"    // Leave it for the next reboot
+)
+
+// Sink delivers a converted document to its destination
+type Sink interface {
+	// Deliver sends the .rmdoc named name into the parent folder
+	Deliver(name string, rmdoc []byte, parent string) error
+	String() string
+}
+
+// SinkConfig describes a sink in the config file
+type SinkConfig struct {
+	Type     string `yaml:"type"`     // web, dir, xochitl or ssh
+	Path     string `yaml:"path"`     // dir and ssh: destination directory, xochitl: data directory (xochitl_dir when empty)
+	Layout   string `yaml:"layout"`   // dir and ssh: rmdoc or xochitl
+	Host     string `yaml:"host"`     // ssh: [user@]host
+	Port     int    `yaml:"port"`     // ssh: port, 22 when 0
+	Identity string `yaml:"identity"` // ssh: private key file
+}
+
+// validate reports the problems of the sink settings, prefixed with key
+func (sc SinkConfig) validate(key string) []error {
+	var errs []error
+	invalid := func(field, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s_%s: "+format, append([]any{key, field}, args...)...))
+	}
+
+	switch sc.Type {
+	case SINK_WEB, SINK_XOCHITL:
+	case SINK_DIR:
+		if sc.Path == "" {
+			invalid("path", "is required for the dir sink")
+		}
+	case SINK_SSH:
+		if sc.Host == "" || sc.Path == "" {
+			invalid("host", "host and path are required for the ssh sink")
+		}
+	default:
+		invalid("type", "%q is not one of web, dir, xochitl, ssh", sc.Type)
+	}
+	switch sc.Layout {
+	case "", LAYOUT_RMDOC, LAYOUT_XOCHITL:
+	default:
+		invalid("layout", "%q is not one of rmdoc, xochitl", sc.Layout)
+	}
+	if sc.Port < 0 || sc.Port > 65535 {
+		invalid("port", "%d is not a port", sc.Port)
+	}
+	return errs
+}
+
+// newSink builds the sink described by sc, taking the shared settings from config
+func newSink(sc SinkConfig, config *Config) (Sink, error) {
+	switch sc.Type {
+	case SINK_WEB, "":
+		return &webSink{url: config.UploadURL}, nil
+	case SINK_DIR:
+		return &dirSink{dir: sc.Path, layout: orDefault(sc.Layout, LAYOUT_RMDOC)}, nil
+	case SINK_XOCHITL:
+		xochitl.configure(config)
+		return &xochitlSink{dir: orDefault(sc.Path, config.XochitlDir)}, nil
+	case SINK_SSH:
+		return &sshSink{config: sc, reload: config.XochitlReload}, nil
+	}
+	return nil, fmt.Errorf("unknown sink type %q", sc.Type)
+}
+
+// webSink uploads to the USB web interface
+type webSink struct {
+	url string
+}
+
+func (sink *webSink) Deliver(name string, rmdoc []byte, parent string) error {
+	return postToLocalWebInterface(httpClient.Load(), sink.url, name, rmdoc, parent)
+}
+
+func (sink *webSink) String() string {
+	return "web interface " + sink.url
+}
+
+// dirSink writes into a local directory, e.g. a synced folder on a desktop
+type dirSink struct {
+	dir    string
+	layout string
+}
+
+func (sink *dirSink) Deliver(name string, rmdoc []byte, parent string) error {
+	if err := os.MkdirAll(sink.dir, 0755); err != nil {
+		return err
+	}
+	if sink.layout == LAYOUT_XOCHITL {
+		_, err := rp.ExtractRmDoc(sink.dir, rmdoc)
+		return err
+	}
+	return writeFileSynced(fp.Join(sink.dir, name), rmdoc)
+}
+
+func (sink *dirSink) String() string {
+	return "directory " + sink.dir
+}
+
+// xochitlSink writes straight into the xochitl data directory. The parent
+// folder is already recorded in the .metadata of the archive.
+type xochitlSink struct {
+	dir string
+}
+
+func (sink *xochitlSink) Deliver(name string, rmdoc []byte, parent string) error {
+	id, err := rp.ExtractRmDoc(sink.dir, rmdoc)
+	if err != nil {
+		return fmt.Errorf("importing %s into %s: %w", name, sink.dir, err)
+	}
+	slog.Debug("imported into xochitl", "name", name, "id", id)
+
+	xochitl.scheduleReload()
+	return nil
+}
+
+func (sink *xochitlSink) String() string {
+	return "xochitl " + sink.dir
+}
+
+// sshSink copies documents to a remote machine, usually a tablet reached
+// over USB or wifi, with the scp and ssh commands
+type sshSink struct {
+	config SinkConfig
+	reload string
+}
+
+func (sink *sshSink) Deliver(name string, rmdoc []byte, parent string) error {
+	staging, err := os.MkdirTemp("", "drawj2d-ssh-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+
+	// Staged in the final layout and copied in one scp call
+	var sources []string
+	if sink.config.Layout == LAYOUT_XOCHITL {
+		id, err := rp.ExtractRmDoc(staging, rmdoc)
+		if err != nil {
+			return err
+		}
+		sources = []string{fp.Join(staging, id), fp.Join(staging, id+".content")}
+		if matches, _ := fp.Glob(fp.Join(staging, id+".pdf")); len(matches) > 0 {
+			sources = append(sources, matches...)
+		}
+		// The metadata goes last: xochitl lists a document as soon as it appears
+		sources = append(sources, fp.Join(staging, id+".metadata"))
+	} else {
+		path := fp.Join(staging, name)
+		if err := os.WriteFile(path, rmdoc, 0644); err != nil {
+			return err
+		}
+		sources = []string{path}
+	}
+
+	args := append(sink.options("-P"), "-r", "-q")
+	args = append(args, sources...)
+	args = append(args, sink.config.Host+":"+strings.TrimSuffix(sink.config.Path, "/")+"/")
+	if err := runCommandOutput("scp", args...); err != nil {
+		return err
+	}
+
+	if sink.config.Layout == LAYOUT_XOCHITL && sink.reload == XOCHITL_RELOAD_RESTART {
+		args := append(sink.options("-p"), sink.config.Host, "systemctl", "restart", "xochitl")
+		return runCommandOutput("ssh", args...)
+	}
+	return nil
+}
+
+// options returns the flags shared by ssh and scp, portFlag differing between them
+func (sink *sshSink) options(portFlag string) []string {
+	args := []string{"-o", "BatchMode=yes"}
+	if sink.config.Port != 0 {
+		args = append(args, portFlag, strconv.Itoa(sink.config.Port))
+	}
+	if sink.config.Identity != "" {
+		args = append(args, "-i", sink.config.Identity)
+	}
+	return args
+}
+
+func (sink *sshSink) String() string {
+	return "ssh " + sink.config.Host + ":" + sink.config.Path
+}
+
+func runCommandOutput(name string, args ...string) error {
+	output, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w: %s", name, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// xochitl restarts the local xochitl after imports
+var xochitl = &xochitlImporter{}
+
+// xochitlImporter restarts xochitl, which only reads its data directory when
+// it starts, once no document has arrived for the reload delay, batching bursts
+type xochitlImporter struct {
+	mu     sync.Mutex
+	reload string
+	delay  time.Duration
+	timer  *time.Timer
+
+	// restart signals xochitl to rescan its directory, restartXochitl outside of tests
+	restart func() error
+}
+
+func (importer *xochitlImporter) configure(config *Config) {
+	importer.mu.Lock()
+	defer importer.mu.Unlock()
+
+	importer.reload = config.XochitlReload
+	importer.delay = config.XochitlReloadDelay
+	if importer.restart == nil {
+		importer.restart = restartXochitl
+	}
+}
+
+func (importer *xochitlImporter) scheduleReload() {
+	importer.mu.Lock()
+	defer importer.mu.Unlock()
+
+	if importer.reload != XOCHITL_RELOAD_RESTART {
+		return
+	}
+	if importer.timer != nil {
+		importer.timer.Stop()
+	}
+	importer.timer = time.AfterFunc(importer.delay, func() {
+		slog.Info("restarting xochitl to load the imported documents")
+		if err := importer.restart(); err != nil {
+			slog.Error("cannot restart xochitl", "err", err)
+		}
+	})
+}
+
+// flush restarts xochitl right away when a restart is pending, so documents
+// imported just before shutting down are not left unloaded
+func (importer *xochitlImporter) flush() {
+	importer.mu.Lock()
+	pending := importer.timer != nil && importer.timer.Stop()
+	importer.mu.Unlock()
+
+	if pending {
+		slog.Info("restarting xochitl to load the imported documents")
+		if err := importer.restart(); err != nil {
+			slog.Error("cannot restart xochitl", "err", err)
+		}
+	}
+}
+
+// loadFolders restarts xochitl right away so that it knows the destination
+// folders just created in its directory: the web interface only uploads into
+// folders xochitl has loaded
+func (importer *xochitlImporter) loadFolders(config *Config) {
+	importer.configure(config)
+	importer.mu.Lock()
+	reload := importer.reload
+	importer.mu.Unlock()
+
+	if reload != XOCHITL_RELOAD_RESTART {
+		slog.Warn("new folders are only shown once xochitl restarts", "xochitl_reload", reload)
+		return
+	}
+	slog.Info("restarting xochitl to load the new folders")
+	if err := importer.restart(); err != nil {
+		slog.Error("cannot restart xochitl", "err", err)
+	}
+}
+
+func restartXochitl() error {
+	return runCommandOutput("systemctl", "restart", "xochitl")
+}
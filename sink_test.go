@@ -0,0 +1,65 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	fp "path/filepath"
+
+	rp "github.com/pragmatically-dev/PoC-drawj2d-port-go/remarkablepage"
+)
+
+func TestXochitlSinkRestartsOnce(t *testing.T) {
+	config := defaultConfig()
+	config.Sink = SinkConfig{Type: SINK_XOCHITL}
+	config.XochitlDir = t.TempDir()
+	config.XochitlReloadDelay = 20 * time.Millisecond
+
+	var restarts atomic.Int32
+	xochitl.restart = func() error {
+		restarts.Add(1)
+		return nil
+	}
+	defer func() { xochitl.restart = nil }()
+
+	sink, err := newSink(config.Sink, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		rmdoc, name, _ := rp.CreateRmDoc("note", [][]byte{rp.NewReMarkablePage().Export()})
+		if err := sink.Deliver(fp.Base(name), rmdoc.Bytes(), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	metadata, _ := fp.Glob(fp.Join(config.XochitlDir, "*.metadata"))
+	pages, _ := fp.Glob(fp.Join(config.XochitlDir, "*", "*-metadata.json"))
+	if len(metadata) != 3 || len(pages) != 3 {
+		t.Fatalf("expected 3 imported documents, got %d metadata and %d pages", len(metadata), len(pages))
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if n := restarts.Load(); n != 1 {
+		t.Fatalf("expected one restart for the burst, got %d", n)
+	}
+}
+
+func TestDirSinkLayouts(t *testing.T) {
+	rmdoc, name, _ := rp.CreateRmDoc("note", [][]byte{rp.NewReMarkablePage().Export()})
+
+	for layout, pattern := range map[string]string{LAYOUT_RMDOC: "note.rmdoc", LAYOUT_XOCHITL: "*/*.rm"} {
+		dir := t.TempDir()
+		sink, err := newSink(SinkConfig{Type: SINK_DIR, Path: dir, Layout: layout}, defaultConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.Deliver(fp.Base(name), rmdoc.Bytes(), ""); err != nil {
+			t.Fatal(err)
+		}
+		if matches, _ := fp.Glob(fp.Join(dir, pattern)); len(matches) != 1 {
+			t.Errorf("%s layout: expected %s in %s", layout, pattern, dir)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	fp "path/filepath"
+
+	rp "github.com/pragmatically-dev/PoC-drawj2d-port-go/remarkablepage"
+)
+
+func testRmDoc(t *testing.T) []byte {
+	t.Helper()
+	rmdoc, _, _ := rp.CreateRmDoc("note", [][]byte{rp.NewReMarkablePage().Export()})
+	return rmdoc.Bytes()
+}
+
+func TestPostToLocalWebInterface(t *testing.T) {
+	fake := newFakeWebInterface(t)
+	rmdoc := testRmDoc(t)
+
+	if err := postToLocalWebInterface(httpClient.Load(), fake.URL, "note.rmdoc", rmdoc, "folder-id"); err != nil {
+		t.Fatal(err)
+	}
+	docs := fake.received()
+	if len(docs) != 1 || docs[0].Name != "note.rmdoc" || docs[0].Parent != "folder-id" {
+		t.Fatalf("unexpected uploads: %+v", docs)
+	}
+
+	resp, err := http.Get(fake.URL + "/download/" + docs[0].ID + "/placeholder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	downloaded, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !bytes.Equal(downloaded, rmdoc) {
+		t.Fatal("downloaded document differs from the upload")
+	}
+
+	fake.failUploads(http.StatusInternalServerError)
+	if err := postToLocalWebInterface(httpClient.Load(), fake.URL, "note.rmdoc", rmdoc, ""); err == nil {
+		t.Fatal("expected an error for a rejected upload")
+	}
+}
+
+func TestPostToLocalWebInterfaceTimeout(t *testing.T) {
+	fake := newFakeWebInterface(t)
+	fake.setLatency(200 * time.Millisecond)
+
+	client := newHTTPClient(50 * time.Millisecond)
+	if err := postToLocalWebInterface(client, fake.URL, "note.rmdoc", testRmDoc(t), ""); err == nil {
+		t.Fatal("expected a timeout")
+	}
+}
+
+func TestUploadQueueAgainstFakeWebInterface(t *testing.T) {
+	fake := newFakeWebInterface(t)
+	fake.failUploads(http.StatusServiceUnavailable, http.StatusBadGateway)
+
+	dir := t.TempDir()
+	source := fp.Join(dir, "Screenshot.png")
+	os.WriteFile(source, []byte("png"), 0644)
+
+	config := testQueueConfig()
+	config.UploadURL = fake.URL
+	queue, err := NewUploadQueue(fp.Join(dir, "queue"), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go queue.Run(stop)
+
+	if err := queue.Enqueue(SinkConfig{Type: SINK_WEB}, "note.rmdoc", testRmDoc(t), "", []string{source}); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for queue.Pending() > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if queue.Pending() != 0 || len(fake.received()) != 1 {
+		t.Fatalf("pending %d, received %d", queue.Pending(), len(fake.received()))
+	}
+	if _, err := os.Stat(source); !os.IsNotExist(err) {
+		t.Fatal("source kept after the upload succeeded")
+	}
+}
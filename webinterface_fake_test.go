@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	rp "github.com/pragmatically-dev/PoC-drawj2d-port-go/remarkablepage"
+)
+
+// receivedDoc is a document uploaded to the fake web interface
+type receivedDoc struct {
+	ID     string
+	Name   string
+	Parent string
+	Data   []byte
+}
+
+// fakeWebInterface is an in-process stand-in for the USB web interface of the
+// tablet. Like the real one it uploads into the folder browsed last through
+// /documents/<id>. Failures and latency can be injected per request.
+type fakeWebInterface struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	folder   string
+	docs     []receivedDoc
+	failures []int // Statuses answered to the next upload requests
+	latency  time.Duration
+}
+
+func newFakeWebInterface(t *testing.T) *fakeWebInterface {
+	fake := &fakeWebInterface{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/documents/", fake.handleDocuments)
+	mux.HandleFunc("/upload", fake.handleUpload)
+	mux.HandleFunc("/download/", fake.handleDownload)
+
+	fake.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fake.mu.Lock()
+		latency := fake.latency
+		fake.mu.Unlock()
+		time.Sleep(latency)
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(fake.Close)
+	return fake
+}
+
+// failUploads makes the next uploads answer the given statuses
+func (fake *fakeWebInterface) failUploads(statuses ...int) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fake.failures = append(fake.failures, statuses...)
+}
+
+func (fake *fakeWebInterface) setLatency(latency time.Duration) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fake.latency = latency
+}
+
+func (fake *fakeWebInterface) received() []receivedDoc {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	return append([]receivedDoc(nil), fake.docs...)
+}
+
+// handleDocuments browses to a folder and lists its documents
+func (fake *fakeWebInterface) handleDocuments(w http.ResponseWriter, r *http.Request) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	fake.folder = strings.TrimPrefix(r.URL.Path, "/documents/")
+
+	type entry struct {
+		ID           string
+		VissibleName string // Sic, as spelled by the tablet
+		Type         string
+		Parent       string
+	}
+	list := []entry{}
+	for _, doc := range fake.docs {
+		if doc.Parent == fake.folder {
+			list = append(list, entry{ID: doc.ID, VissibleName: doc.Name, Type: "DocumentType", Parent: doc.Parent})
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func (fake *fakeWebInterface) handleUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	fake.mu.Lock()
+	if len(fake.failures) > 0 {
+		status := fake.failures[0]
+		fake.failures = fake.failures[1:]
+		fake.mu.Unlock()
+		http.Error(w, "injected failure", status)
+		return
+	}
+	fake.mu.Unlock()
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	info, err := rp.ReadRmDoc(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	fake.mu.Lock()
+	fake.docs = append(fake.docs, receivedDoc{ID: info.ID, Name: header.Filename, Parent: fake.folder, Data: data})
+	fake.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte("{}"))
+}
+
+// handleDownload serves /download/<id>/placeholder with the stored archive
+func (fake *fakeWebInterface) handleDownload(w http.ResponseWriter, r *http.Request) {
+	id, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/download/"), "/")
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	for _, doc := range fake.docs {
+		if doc.ID == id {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(doc.Data)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}